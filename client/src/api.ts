@@ -4,6 +4,7 @@ import {
   AuthResponse,
   GetChatsResponse,
   GetMessagesResponse,
+  Message,
   SendMessageRequest,
   User,
 } from './types';
@@ -69,16 +70,16 @@ class ApiClient {
   }
 
   // Auth endpoints
-  async login(username: string): Promise<AuthResponse> {
-    const data: LoginRequest = { Username: username };
+  async login(username: string, password: string): Promise<AuthResponse> {
+    const data: LoginRequest = { Username: username, Password: password };
     return this.request('/api/auth/login', {
       method: 'POST',
       body: JSON.stringify(data),
     });
   }
 
-  async register(username: string): Promise<AuthResponse> {
-    const data: RegisterRequest = { Username: username };
+  async register(username: string, password: string): Promise<AuthResponse> {
+    const data: RegisterRequest = { Username: username, Password: password };
     return this.request('/api/auth/register', {
       method: 'POST',
       body: JSON.stringify(data),
@@ -125,8 +126,8 @@ class ApiClient {
     return this.request(`/api/chats/${chatId}/messages?limit=${limit}`);
   }
 
-  async sendMessage(chatId: number, text: string): Promise<{ message: string }> {
-    const data: SendMessageRequest = { Text: text };
+  async sendMessage(chatId: number, text: string, clientMsgId?: string): Promise<{ message: Message }> {
+    const data: SendMessageRequest = { Text: text, clientMsgId };
     return this.request(`/api/chats/${chatId}/messages`, {
       method: 'POST',
       body: JSON.stringify(data),
@@ -7,8 +7,8 @@ interface AuthContextType {
   user: User | null;
   token: string | null;
   isLoading: boolean;
-  login: (username: string) => Promise<void>;
-  register: (username: string) => Promise<void>;
+  login: (username: string, password: string) => Promise<void>;
+  register: (username: string, password: string) => Promise<void>;
   logout: () => void;
   isAuthenticated: boolean;
   validateToken: () => Promise<boolean>;
@@ -66,8 +66,8 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     });
   }, []);
 
-  const login = async (username: string) => {
-    const response = await apiClient.login(username);
+  const login = async (username: string, password: string) => {
+    const response = await apiClient.login(username, password);
     const token = response.Token || (response as any).token;
     const user = response.User || (response as any).user;
     setToken(token);
@@ -77,8 +77,8 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     apiClient.setToken(token);
   };
 
-  const register = async (username: string) => {
-    const response = await apiClient.register(username);
+  const register = async (username: string, password: string) => {
+    const response = await apiClient.register(username, password);
     const token = response.Token || (response as any).token;
     const user = response.User || (response as any).user;
     setToken(token);
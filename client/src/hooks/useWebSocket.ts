@@ -3,8 +3,8 @@ import { apiClient } from '../api';
 import { Message } from '../types';
 
 interface WebSocketMessage {
-  type: 'message';
-  data: Message;
+  type: 'message.created';
+  payload: Message;
 }
 
 export function useWebSocket(
@@ -41,8 +41,8 @@ export function useWebSocket(
     websocket.onmessage = (event) => {
       try {
         const message: WebSocketMessage = JSON.parse(event.data);
-        if (message.type === 'message' && message.data) {
-          onMessageReceived(message.data);
+        if (message.type === 'message.created' && message.payload) {
+          onMessageReceived(message.payload);
         }
       } catch (e) {
         console.error('Failed to parse WebSocket message:', e);
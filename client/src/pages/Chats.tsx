@@ -3,7 +3,23 @@ import { useState, useEffect, useCallback } from 'react';
 import { useAuth } from '../contexts/AuthContext';
 import { useNavigate } from 'react-router-dom';
 import { apiClient } from '../api';
-import { Chat, Message, User } from '../types';
+import {
+  Chat,
+  ChatDeletedPayload,
+  Message,
+  MessageDeletedPayload,
+  ReactionUpdatedPayload,
+  ThreadUpdatedPayload,
+  User,
+  WebSocketErrorPayload,
+  WebSocketEvent,
+  WebSocketRequest,
+  WS_PROTOCOL_VERSION,
+} from '../types';
+
+let requestCounter = 0;
+
+const newRequestId = () => `${Date.now()}-${++requestCounter}`;
 import ChatList from '../components/ChatList';
 import ChatWindow from '../components/ChatWindow';
 import NewChatModal from '../components/NewChatModal';
@@ -97,17 +113,23 @@ export default function Chats() {
 
     // Send via WebSocket if connected
     if (ws && ws.readyState === WebSocket.OPEN) {
-      const wsMessage = {
-        text: text,
-        chatId: selectedChat.ID,
-        userId: user.ID,
+      const request: WebSocketRequest = {
+        v: WS_PROTOCOL_VERSION,
+        type: 'message.send',
+        id: newRequestId(),
+        payload: {
+          chatId: selectedChat.ID,
+          text: text,
+          // deduplicates a resend of this message, the request id only correlates the ack
+          clientMsgId: newRequestId(),
+        },
       };
-      ws.send(JSON.stringify(wsMessage));
+      ws.send(JSON.stringify(request));
     } else {
       // Fallback to REST API if WebSocket not connected
       // Message will be added when received via WebSocket
       try {
-        await apiClient.sendMessage(selectedChat.ID, text);
+        await apiClient.sendMessage(selectedChat.ID, text, newRequestId());
       } catch (error) {
         console.error('Failed to send message:', error);
       }
@@ -149,44 +171,121 @@ export default function Chats() {
       setWs(wsConnection);
     };
 
-    wsConnection.onclose = () => {
-      console.log('WebSocket disconnected');
+    wsConnection.onclose = (event) => {
+      console.log('WebSocket disconnected', event.code, event.reason);
       setWs(null);
     };
 
-    wsConnection.onmessage = (event) => {
-      console.log('WebSocket message received:', event.data);
-      try {
-        const data = JSON.parse(event.data);
-        console.log('Parsed WebSocket data:', data);
-        
-        // Backend sends message directly without type wrapper
-        // Check if it's a message object (has ID and text)
-        const newMessage: Message = data;
-        
-        if (newMessage.ID && (newMessage.text || newMessage.Text)) {
-          const chatId = (newMessage as Message).ChatID || (newMessage as any).chat_id || (newMessage as any).chatId;
-          console.log('New message for chat:', chatId, 'current selectedChat:', selectedChat);
-          
-          // Use functional update to get latest state
+    const handleEvent = (wsEvent: WebSocketEvent) => {
+      switch (wsEvent.type) {
+        case 'message.created':
+        case 'message.edited': {
+          const newMessage = wsEvent.payload as Message;
+          const chatId = newMessage.ChatID || newMessage.chat_id || newMessage.chatId;
+          const authorId = newMessage.UserID || newMessage.user_id || newMessage.userId;
+
+          // Confirm delivery so the sender sees the message as delivered
+          if (wsEvent.type === 'message.created' && authorId !== user?.ID) {
+            const ack: WebSocketRequest = {
+              v: WS_PROTOCOL_VERSION,
+              type: 'message.delivered',
+              id: newRequestId(),
+              payload: { chatId, messageId: newMessage.ID },
+            };
+            wsConnection.send(JSON.stringify(ack));
+          }
+
+          if (chatId !== selectedChat.ID) return;
+          // replies kept inside a thread are not part of the chat timeline
+          if (newMessage.threadRootId && !newMessage.alsoSentToChat) return;
+
           setMessages((prev) => {
-            console.log('Updating messages, prev length:', prev.length);
-            
-            // Check if message with this ID already exists
             const exists = prev.some((m) => m.ID === newMessage.ID);
             if (exists) {
-              console.log('Message already exists, skipping');
-              return prev;
+              // reactions are counted per viewer and don't come with pushed messages
+              return prev.map((m) =>
+                m.ID === newMessage.ID ? { ...newMessage, reactions: newMessage.reactions ?? m.reactions } : m
+              );
             }
-            
-            console.log('Adding new message from WebSocket');
             return [...prev, newMessage];
           });
-        } else {
-          console.log('Not a message object, skipping', data);
+          break;
+        }
+        case 'message.deleted': {
+          const { messageId } = wsEvent.payload as MessageDeletedPayload;
+          setMessages((prev) => prev.filter((m) => m.ID !== messageId));
+          break;
+        }
+        case 'reaction.updated': {
+          const update = wsEvent.payload as ReactionUpdatedPayload;
+          if (update.chatId !== selectedChat.ID) return;
+
+          setMessages((prev) =>
+            prev.map((m) => {
+              if (m.ID !== update.messageId) return m;
+              const others = (m.reactions || []).filter((r) => r.emoji !== update.emoji);
+              const current = (m.reactions || []).find((r) => r.emoji === update.emoji);
+              if (update.count === 0) {
+                return { ...m, reactions: others };
+              }
+              const reacted = update.userId === user?.ID ? update.added : current?.reacted ?? false;
+              const reaction = { emoji: update.emoji, count: update.count, reacted };
+              return {
+                ...m,
+                reactions: current
+                  ? (m.reactions || []).map((r) => (r.emoji === update.emoji ? reaction : r))
+                  : [...others, reaction],
+              };
+            })
+          );
+          break;
+        }
+        case 'thread.updated': {
+          const update = wsEvent.payload as ThreadUpdatedPayload;
+          if (update.chatId !== selectedChat.ID) return;
+
+          setMessages((prev) =>
+            prev.map((m) =>
+              m.ID === update.rootMessageId
+                ? { ...m, threadReplyCount: update.replyCount, threadLastReplyAt: update.lastReplyAt ?? undefined }
+                : m
+            )
+          );
+          break;
+        }
+        case 'chat.deleted': {
+          const { chatId } = wsEvent.payload as ChatDeletedPayload;
+          if (chatId === selectedChat.ID) {
+            setSelectedChat(null);
+          }
+          loadChats();
+          break;
+        }
+        case 'resync.required':
+          // some events were dropped on the way, reload what is on screen
+          loadChats();
+          loadMessages(selectedChat.ID);
+          break;
+        case 'ack':
+          break;
+        case 'error': {
+          const { code, message } = wsEvent.payload as WebSocketErrorPayload;
+          console.error(`WebSocket request ${wsEvent.id} failed: ${code}: ${message}`);
+          break;
+        }
+        default:
+          console.log('Unknown WebSocket event, skipping', wsEvent);
+      }
+    };
+
+    wsConnection.onmessage = (event) => {
+      // the server may batch several events into one message, one per line
+      for (const line of (event.data as string).split('\n')) {
+        try {
+          handleEvent(JSON.parse(line));
+        } catch (e) {
+          console.error('Failed to parse WebSocket message:', e);
         }
-      } catch (e) {
-        console.error('Failed to parse WebSocket message:', e);
       }
     };
 
@@ -200,7 +299,7 @@ export default function Chats() {
       }
       setWs(null);
     };
-  }, [selectedChat, token]);
+  }, [selectedChat, token, user, loadChats, loadMessages]);
 
   return (
     <div className="h-screen flex bg-dark-900">
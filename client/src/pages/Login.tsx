@@ -4,6 +4,7 @@ import { useNavigate, Link } from 'react-router-dom';
 
 export default function Login() {
   const [username, setUsername] = useState('');
+  const [password, setPassword] = useState('');
   const [error, setError] = useState('');
   const [isLoading, setIsLoading] = useState(false);
   const { login } = useAuth();
@@ -19,7 +20,11 @@ export default function Login() {
         setError('Please enter a username');
         return;
       }
-      await login(username);
+      if (!password) {
+        setError('Please enter a password');
+        return;
+      }
+      await login(username, password);
       navigate('/chats');
     } catch (err) {
       setError(err instanceof Error ? err.message : 'Login failed');
@@ -70,6 +75,21 @@ export default function Login() {
               />
             </div>
 
+            <div>
+              <label htmlFor="password" className="block text-sm font-medium text-dark-300 mb-2">
+                Password
+              </label>
+              <input
+                id="password"
+                type="password"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                className="w-full px-4 py-3 bg-dark-900/50 border border-dark-600 rounded-xl text-white placeholder-dark-500 focus:outline-none focus:border-primary-500 focus:ring-2 focus:ring-primary-500/20 transition-all input-focus"
+                placeholder="Enter your password"
+                autoComplete="current-password"
+              />
+            </div>
+
             {error && (
               <div className="bg-red-500/10 border border-red-500/50 rounded-xl p-4 flex items-center gap-3">
                 <svg className="w-5 h-5 text-red-500 flex-shrink-0" fill="currentColor" viewBox="0 0 20 20">
@@ -34,6 +34,44 @@ export interface Message {
   UpdatedAt?: string;
   DeletedAt?: string | null;
   User?: User;
+  attachments?: Attachment[];
+  reactions?: ReactionCount[];
+  replyToMessageId?: number;
+  replyTo?: MessagePreview;
+  threadRootId?: number;
+  alsoSentToChat?: boolean;
+  threadReplyCount?: number;
+  threadLastReplyAt?: string;
+}
+
+export interface MessagePreview {
+  messageId: number;
+  userId: number;
+  authorName: string;
+  text: string;
+  hasAttachments: boolean;
+  deleted: boolean;
+}
+
+export interface ReactionCount {
+  emoji: string;
+  count: number;
+  reacted: boolean;
+}
+
+export interface Attachment {
+  ID: number;
+  messageId: number | null;
+  chatId: number;
+  uploaderId: number;
+  fileName: string;
+  mimeType: string;
+  size: number;
+  width?: number;
+  height?: number;
+  thumbnailWidth?: number;
+  thumbnailHeight?: number;
+  hasThumbnail: boolean;
 }
 
 export interface AuthResponse {
@@ -47,10 +85,12 @@ export interface AuthResponse {
 
 export interface LoginRequest {
   Username: string;
+  Password: string;
 }
 
 export interface RegisterRequest {
   Username: string;
+  Password: string;
 }
 
 export interface CreateChatRequest {
@@ -59,6 +99,11 @@ export interface CreateChatRequest {
 
 export interface SendMessageRequest {
   Text: string;
+  clientMsgId?: string;
+  attachmentIds?: number[];
+  replyToMessageId?: number;
+  threadRootId?: number;
+  alsoSendToChat?: boolean;
 }
 
 export interface GetChatsResponse {
@@ -70,3 +115,93 @@ export interface GetMessagesResponse {
   messages?: Message[];
   Messages?: Message[];
 }
+
+export const WS_PROTOCOL_VERSION = 1;
+
+export type WebSocketEventType =
+  | 'message.created'
+  | 'message.edited'
+  | 'message.deleted'
+  | 'chat.deleted'
+  | 'read.updated'
+  | 'delivery.updated'
+  | 'typing.started'
+  | 'typing.stopped'
+  | 'presence.updated'
+  | 'reaction.updated'
+  | 'thread.updated'
+  | 'resync.required'
+  | 'ack'
+  | 'error';
+
+export interface WebSocketEvent {
+  v: number;
+  type: WebSocketEventType;
+  id?: string;
+  payload: unknown;
+}
+
+export type WebSocketRequestType =
+  | 'message.send'
+  | 'message.edit'
+  | 'chat.read'
+  | 'message.delivered'
+  | 'typing.start'
+  | 'typing.stop'
+  | 'sync'
+  | 'reaction.add'
+  | 'reaction.remove';
+
+export interface WebSocketRequest {
+  v: number;
+  type: WebSocketRequestType;
+  id: string;
+  payload: object;
+}
+
+export interface WebSocketErrorPayload {
+  code: string;
+  message: string;
+}
+
+export interface ReactionUpdatedPayload {
+  messageId: number;
+  chatId: number;
+  userId: number;
+  emoji: string;
+  added: boolean;
+  count: number;
+}
+
+export interface ThreadUpdatedPayload {
+  chatId: number;
+  rootMessageId: number;
+  replyCount: number;
+  lastReplyAt: string | null;
+}
+
+export interface MessageDeletedPayload {
+  messageId: number;
+  chatId: number;
+}
+
+export interface ChatDeletedPayload {
+  chatId: number;
+}
+
+export interface SyncResultPayload {
+  chats: {
+    chatId: number;
+    lastSeq: number;
+    new: boolean;
+    events: WebSocketEvent[];
+  }[];
+  removedChatIds: number[] | null;
+  hasMore: boolean;
+}
+
+export interface PresencePayload {
+  userId: number;
+  online: boolean;
+  lastSeenAt: string | null;
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"os"
+	"simpleMessenger/internal/broker"
 	"simpleMessenger/internal/db"
 	"simpleMessenger/internal/repository/postgres"
 	"simpleMessenger/internal/service"
+	"simpleMessenger/internal/storage"
 	"simpleMessenger/internal/transport/http"
 	"simpleMessenger/internal/transport/websocket"
+
+	"gorm.io/gorm"
 )
 
 func main() {
@@ -18,26 +23,55 @@ func main() {
 	userRepo := postgres.NewUserRepository(database)
 	chatRepo := postgres.NewChatRepository(database)
 	messageRepo := postgres.NewMessageRepository(database)
+	messageRevisionRepo := postgres.NewMessageRevisionRepository(database)
 	chatParticipantsRepo := postgres.NewChatParticipantsRepository(database)
+	sessionRepo := postgres.NewSessionRepository(database)
+	attachmentRepo := postgres.NewAttachmentRepository(database)
+	reactionRepo := postgres.NewReactionRepository(database)
+
+	blobStore, err := storage.NewLocalBlobStore(getEnv("BLOB_STORAGE_DIR", "./data/blobs"))
+	if err != nil {
+		log.Fatalf("failed to open blob storage: %v", err)
+	}
 
 	secret := getEnv("JWT_SECRET_KEY", "")
 
-	tokenService := service.NewJwtService(secret)
+	tokenService := service.NewJwtService(secret, sessionRepo)
 	authService := service.NewAuthService(userRepo, tokenService)
 	userService := service.NewUserService(userRepo)
-	chatService := service.NewChatService(chatRepo, chatParticipantsRepo, messageRepo, userRepo)
-	messageService := service.NewMessageService(messageRepo, chatRepo, chatParticipantsRepo)
 
-	authHandler := http.NewAuthHandler(authService)
-	userHandler := http.NewUserHandler(userService)
-	chatHandler := http.NewChatHandler(chatService)
-	messageHandler := http.NewMessageHandler(messageService)
+	hubBroker := createBroker(database, dsn)
+	defer hubBroker.Close()
+
+	chatMembers := service.NewMembershipCache(chatParticipantsRepo, hubBroker)
+	chatService := service.NewChatService(chatRepo, chatParticipantsRepo, messageRepo, userRepo, chatMembers)
+	messageService := service.NewMessageService(messageRepo, messageRevisionRepo, attachmentRepo, reactionRepo, chatRepo, chatParticipantsRepo, chatMembers)
+
+	attachmentService := service.NewAttachmentService(attachmentRepo, messageRepo, blobStore, chatMembers)
+	go attachmentService.RunCleanup()
+
+	slowConsumerPolicy, err := websocket.ParseSlowConsumerPolicy(getEnv("WS_SLOW_CONSUMER_POLICY", string(websocket.PolicyDisconnect)))
+	if err != nil {
+		log.Fatalf("invalid WS_SLOW_CONSUMER_POLICY: %v", err)
+	}
 
-	wsHub := websocket.NewHub(messageService, chatService)
+	wsHub := websocket.NewHub(hubBroker, slowConsumerPolicy, messageService, chatService, userService, chatMembers)
 	go wsHub.Run()
 
+	if metricsAddr := getEnv("METRICS_ADDR", ""); metricsAddr != "" {
+		go func() {
+			log.Printf("failed to serve metrics: %v", http.ServeMetrics(metricsAddr, wsHub))
+		}()
+	}
+
+	authHandler := http.NewAuthHandler(authService)
+	userHandler := http.NewUserHandler(userService, chatService, wsHub)
+	chatHandler := http.NewChatHandler(chatService, wsHub)
+	messageHandler := http.NewMessageHandler(messageService, wsHub)
+	attachmentHandler := http.NewAttachmentHandler(attachmentService)
+
 	r := http.NewRouter()
-	r.SetupRouter(authHandler, userHandler, chatHandler, messageHandler, tokenService, wsHub)
+	r.SetupRouter(authHandler, userHandler, chatHandler, messageHandler, attachmentHandler, tokenService, wsHub)
 	r.Run()
 }
 
@@ -50,6 +84,24 @@ func getEnv(key, defaultValue string) string {
 	}
 }
 
+// createBroker picks how hub frames and cache invalidations reach other instances.
+// "postgres" is needed as soon as more than one replica runs.
+func createBroker(database *gorm.DB, dsn string) broker.Broker {
+	switch kind := getEnv("HUB_BROKER", "memory"); kind {
+	case "memory":
+		return broker.NewMemoryBroker()
+	case "postgres":
+		pgBroker, err := broker.NewPostgresBroker(database, dsn)
+		if err != nil {
+			log.Fatalf("failed to start postgres broker: %v", err)
+		}
+		return pgBroker
+	default:
+		log.Fatalf("unknown HUB_BROKER %q, expected memory or postgres", kind)
+		return nil
+	}
+}
+
 func createDSN() string {
 	host := getEnv("DB_HOST", "localhost")
 	user := getEnv("DB_USER", "postgres")
@@ -0,0 +1,21 @@
+package broker
+
+// Message is a frame for a set of users. Whichever server instance the users are
+// connected to delivers it, the publisher does not need to know which one that is.
+// Messages with a Topic are not frames but coordination between instances, Data is
+// their JSON payload and UserIDs is unused.
+type Message struct {
+	Topic   string
+	UserIDs []uint
+	Data    []byte
+}
+
+// Handler is called for every published message, including those published by the
+// same instance. It must not block.
+type Handler func(message *Message)
+
+type Broker interface {
+	Publish(message *Message) error
+	Subscribe(handler Handler)
+	Close() error
+}
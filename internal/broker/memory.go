@@ -0,0 +1,35 @@
+package broker
+
+import "sync"
+
+// memoryBroker hands messages straight to the subscribers of this process. It is all a
+// single instance needs and keeps delivery synchronous.
+type memoryBroker struct {
+	mu       sync.RWMutex
+	handlers []Handler
+}
+
+func NewMemoryBroker() Broker {
+	return &memoryBroker{}
+}
+
+func (b *memoryBroker) Publish(message *Message) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, handler := range b.handlers {
+		handler(message)
+	}
+	return nil
+}
+
+func (b *memoryBroker) Subscribe(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *memoryBroker) Close() error {
+	return nil
+}
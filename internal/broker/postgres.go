@@ -0,0 +1,200 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	notifyChannel = "simple_messenger_hub"
+	// postgres refuses NOTIFY payloads of 8000 bytes or more, bigger frames go through a table
+	maxNotifyPayload = 7900
+	// spilled frames only have to live until every instance has read them
+	spillRetention = time.Minute
+
+	reconnectDelay = time.Second
+)
+
+// spilledMessage holds a frame too big for a NOTIFY payload
+type spilledMessage struct {
+	ID        uint64 `gorm:"primaryKey"`
+	Payload   []byte `gorm:"column:payload; not null"`
+	CreatedAt time.Time
+}
+
+func (spilledMessage) TableName() string {
+	return "broker_messages"
+}
+
+type notification struct {
+	Topic   string          `json:"t,omitempty"`
+	UserIDs []uint          `json:"u,omitempty"`
+	Data    json.RawMessage `json:"d,omitempty"`
+	// SpillID points at a spilledMessage holding the actual notification
+	SpillID uint64 `json:"s,omitempty"`
+}
+
+// postgresBroker fans messages out to every server instance with LISTEN/NOTIFY on the
+// database they already share. Frames must be JSON, as everything the hub sends is.
+type postgresBroker struct {
+	db     *gorm.DB
+	dsn    string
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.RWMutex
+	handlers []Handler
+}
+
+func NewPostgresBroker(db *gorm.DB, dsn string) (Broker, error) {
+	err := db.AutoMigrate(&spilledMessage{})
+	if err != nil {
+		return nil, fmt.Errorf("migrate broker messages: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	conn, err := listen(ctx, dsn)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	b := &postgresBroker{
+		db:     db,
+		dsn:    dsn,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go b.run(ctx, conn)
+
+	return b, nil
+}
+
+func (b *postgresBroker) Publish(message *Message) error {
+	payload, err := json.Marshal(&notification{Topic: message.Topic, UserIDs: message.UserIDs, Data: message.Data})
+	if err != nil {
+		return fmt.Errorf("marshal broker message: %w", err)
+	}
+
+	if len(payload) > maxNotifyPayload {
+		spilled := &spilledMessage{Payload: payload}
+		if err := b.db.Create(spilled).Error; err != nil {
+			return fmt.Errorf("spill broker message: %w", err)
+		}
+
+		payload, err = json.Marshal(&notification{SpillID: spilled.ID})
+		if err != nil {
+			return fmt.Errorf("marshal broker message: %w", err)
+		}
+
+		b.db.Where("created_at < ?", time.Now().Add(-spillRetention)).Delete(&spilledMessage{})
+	}
+
+	err = b.db.Exec("SELECT pg_notify(?, ?)", notifyChannel, string(payload)).Error
+	if err != nil {
+		return fmt.Errorf("notify broker message: %w", err)
+	}
+	return nil
+}
+
+func (b *postgresBroker) Subscribe(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *postgresBroker) Close() error {
+	b.cancel()
+	<-b.done
+	return nil
+}
+
+// run waits for notifications on a dedicated connection and listens again whenever it breaks.
+// Messages sent while it is reconnecting are lost, like those of a dropped websocket.
+func (b *postgresBroker) run(ctx context.Context, conn *pgx.Conn) {
+	defer close(b.done)
+
+	for {
+		if conn == nil {
+			var err error
+			conn, err = listen(ctx, b.dsn)
+			if err != nil {
+				log.Printf("failed to listen for broker messages: %v", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(reconnectDelay):
+				}
+				continue
+			}
+		}
+
+		pgNotification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			conn.Close(context.Background())
+			conn = nil
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return
+			}
+			log.Printf("lost broker connection: %v", err)
+			continue
+		}
+
+		message, err := b.decode(pgNotification.Payload)
+		if err != nil {
+			log.Printf("failed to decode broker message: %v", err)
+			continue
+		}
+
+		b.mu.RLock()
+		for _, handler := range b.handlers {
+			handler(message)
+		}
+		b.mu.RUnlock()
+	}
+}
+
+func (b *postgresBroker) decode(payload string) (*Message, error) {
+	n := &notification{}
+	if err := json.Unmarshal([]byte(payload), n); err != nil {
+		return nil, err
+	}
+
+	if n.SpillID != 0 {
+		spilled := &spilledMessage{}
+		if err := b.db.Where("id = ?", n.SpillID).First(spilled).Error; err != nil {
+			return nil, fmt.Errorf("get spilled message %d: %w", n.SpillID, err)
+		}
+
+		n = &notification{}
+		if err := json.Unmarshal(spilled.Payload, n); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Message{Topic: n.Topic, UserIDs: n.UserIDs, Data: n.Data}, nil
+}
+
+func listen(ctx context.Context, dsn string) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("connect broker listener: %w", err)
+	}
+
+	_, err = conn.Exec(ctx, "LISTEN "+notifyChannel)
+	if err != nil {
+		conn.Close(context.Background())
+		return nil, fmt.Errorf("listen on %s: %w", notifyChannel, err)
+	}
+
+	return conn, nil
+}
@@ -8,15 +8,53 @@ import (
 )
 
 func InitDB(dsn string) *gorm.DB {
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatalf("failed to connect database: %v", err)
 	}
 	log.Println("Connected to database")
 
-	err = db.AutoMigrate(&model.User{}, &model.Chat{}, &model.Message{}, &model.ChatParticipants{})
+	err = db.AutoMigrate(&model.User{}, &model.Chat{}, &model.Message{}, &model.ChatParticipants{}, &model.Session{}, &model.MessageRevision{}, &model.Attachment{}, &model.Reaction{})
 	if err != nil {
 		log.Fatalf("failed to migrate database: %v", err)
 	}
+
+	// keyset pagination walks messages of a chat in (created_at, id) order
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_messages_chat_created_id ON messages (chat_id, created_at, id)").Error
+	if err != nil {
+		log.Fatalf("failed to create messages pagination index: %v", err)
+	}
+
+	// threads are paged the same way, only replies have a root
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_messages_thread_created_id ON messages (thread_root_id, created_at, id) WHERE thread_root_id IS NOT NULL").Error
+	if err != nil {
+		log.Fatalf("failed to create thread pagination index: %v", err)
+	}
+
+	// messages written before sequences existed are numbered in their chat's timeline order
+	err = db.Exec(`
+		WITH numbered AS (
+			SELECT m.id, c.last_seq + ROW_NUMBER() OVER (PARTITION BY m.chat_id ORDER BY m.created_at, m.id) AS seq
+			FROM messages m JOIN chats c ON c.id = m.chat_id
+			WHERE m.seq = 0
+		)
+		UPDATE messages SET seq = numbered.seq, created_seq = numbered.seq
+		FROM numbered WHERE messages.id = numbered.id`).Error
+	if err != nil {
+		log.Fatalf("failed to backfill message sequences: %v", err)
+	}
+
+	err = db.Exec("UPDATE chats SET last_seq = sub.max_seq FROM (SELECT chat_id, MAX(seq) AS max_seq FROM messages GROUP BY chat_id) sub WHERE chats.id = sub.chat_id AND chats.last_seq < sub.max_seq").Error
+	if err != nil {
+		log.Fatalf("failed to backfill chat sequences: %v", err)
+	}
+
+	// participants of direct chats created before roles existed own their chats
+	err = db.Model(&model.ChatParticipants{}).
+		Where("role = ? AND chat_id IN (?)", model.ChatRoleMember, db.Model(&model.Chat{}).Select("id").Where("is_group = ?", false)).
+		Update("role", model.ChatRoleOwner).Error
+	if err != nil {
+		log.Fatalf("failed to backfill chat roles: %v", err)
+	}
 	return db
 }
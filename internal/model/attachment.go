@@ -0,0 +1,30 @@
+package model
+
+import "gorm.io/gorm"
+
+// Attachment is a file uploaded into a chat. It stays unlinked until the uploader sends
+// a message with it; the content itself lives in a blob store under StorageKey.
+type Attachment struct {
+	gorm.Model
+	MessageID  *uint  `gorm:"column:message_id; index" json:"messageId"`
+	ChatID     uint   `gorm:"column:chat_id; not null; index" json:"chatId"`
+	UploaderID uint   `gorm:"column:uploader_id; not null" json:"uploaderId"`
+	FileName   string `gorm:"column:file_name; not null" json:"fileName"`
+	MimeType   string `gorm:"column:mime_type; not null" json:"mimeType"`
+	Size       int64  `gorm:"column:size; not null" json:"size"`
+	StorageKey string `gorm:"column:storage_key; not null; uniqueIndex" json:"-"`
+	// Width and Height are known for images, so clients can reserve space before loading them
+	Width  int `gorm:"column:width; not null; default:0" json:"width,omitempty"`
+	Height int `gorm:"column:height; not null; default:0" json:"height,omitempty"`
+	// ThumbnailKey is empty when no thumbnail could be made
+	ThumbnailKey      string `gorm:"column:thumbnail_key; not null; default:''" json:"-"`
+	ThumbnailMimeType string `gorm:"column:thumbnail_mime_type; not null; default:''" json:"-"`
+	ThumbnailWidth    int    `gorm:"column:thumbnail_width; not null; default:0" json:"thumbnailWidth,omitempty"`
+	ThumbnailHeight   int    `gorm:"column:thumbnail_height; not null; default:0" json:"thumbnailHeight,omitempty"`
+	HasThumbnail      bool   `gorm:"-" json:"hasThumbnail"`
+}
+
+func (a *Attachment) AfterFind(tx *gorm.DB) error {
+	a.HasThumbnail = a.ThumbnailKey != ""
+	return nil
+}
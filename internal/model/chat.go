@@ -8,5 +8,8 @@ import (
 type Chat struct {
 	gorm.Model
 	Name          string `gorm:"column:name; not null" json:"name"`
+	IsGroup       bool   `gorm:"column:is_group; not null; default:false" json:"isGroup"`
 	LastMessageAt time.Time
+	// LastSeq is the sequence number of the latest change to a message in the chat
+	LastSeq uint64 `gorm:"column:last_seq; not null; default:0" json:"lastSeq"`
 }
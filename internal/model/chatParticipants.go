@@ -1,9 +1,27 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+type ChatRole string
+
+const (
+	ChatRoleOwner  ChatRole = "owner"
+	ChatRoleAdmin  ChatRole = "admin"
+	ChatRoleMember ChatRole = "member"
+)
 
 type ChatParticipants struct {
 	gorm.Model
-	ChatID uint `gorm:"column:chat_id; not null" json:"chatId"`
-	UserID uint `gorm:"column:user_id; not null" json:"userId"`
+	ChatID uint     `gorm:"column:chat_id; not null" json:"chatId"`
+	UserID uint     `gorm:"column:user_id; not null" json:"userId"`
+	Role   ChatRole `gorm:"column:role; not null; default:'member'" json:"role"`
+	// LastDeliveredMessageID and LastReadMessageID only move forward: everything up to
+	// them has reached a device of the participant or has been seen by them
+	LastDeliveredMessageID *uint      `gorm:"column:last_delivered_message_id" json:"lastDeliveredMessageId"`
+	LastDeliveredAt        *time.Time `gorm:"column:last_delivered_at" json:"lastDeliveredAt"`
+	LastReadMessageID      *uint      `gorm:"column:last_read_message_id" json:"lastReadMessageId"`
+	LastReadAt             *time.Time `gorm:"column:last_read_at" json:"lastReadAt"`
 }
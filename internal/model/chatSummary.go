@@ -0,0 +1,17 @@
+package model
+
+// ChatSummary is a chat list entry with everything the list needs to render it
+type ChatSummary struct {
+	Chat         *Chat         `json:"chat"`
+	LastMessage  *Message      `json:"lastMessage"`
+	Participants []*ChatMember `json:"participants"`
+	UnreadCount  int64         `json:"unreadCount"`
+}
+
+// ChatMember is a short public view of a chat participant
+type ChatMember struct {
+	UserID uint     `json:"userId"`
+	Login  string   `json:"login"`
+	Name   string   `json:"name"`
+	Role   ChatRole `json:"role"`
+}
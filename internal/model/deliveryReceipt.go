@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+type MessageStatus string
+
+const (
+	MessageStatusSent      MessageStatus = "sent"
+	MessageStatusDelivered MessageStatus = "delivered"
+	MessageStatusRead      MessageStatus = "read"
+)
+
+// DeliveryReceipt tells up to which message a chat has reached a participant's device
+type DeliveryReceipt struct {
+	ChatID                 uint      `json:"chatId"`
+	UserID                 uint      `json:"userId"`
+	LastDeliveredMessageID uint      `json:"lastDeliveredMessageId"`
+	DeliveredAt            time.Time `json:"deliveredAt"`
+}
+
+// RecipientStatus is the state of one message for one of the other chat participants
+type RecipientStatus struct {
+	UserID      uint          `json:"userId"`
+	Status      MessageStatus `json:"status"`
+	DeliveredAt *time.Time    `json:"deliveredAt"`
+	ReadAt      *time.Time    `json:"readAt"`
+}
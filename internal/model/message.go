@@ -1,10 +1,56 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"time"
+)
 
 type Message struct {
 	gorm.Model
-	Text   string `gorm:"column:text; not null" json:"text"`
-	ChatID uint   `gorm:"column:chat_id; not null" json:"chatId"`
-	UserID uint   `gorm:"column:user_id; not null" json:"userId"`
+	Text     string     `gorm:"column:text; not null" json:"text"`
+	ChatID   uint       `gorm:"column:chat_id; not null; uniqueIndex:idx_messages_client_msg_id,priority:2; index:idx_messages_chat_seq,priority:1" json:"chatId"`
+	UserID   uint       `gorm:"column:user_id; not null; uniqueIndex:idx_messages_client_msg_id,priority:1" json:"userId"`
+	EditedAt *time.Time `gorm:"column:edited_at" json:"editedAt"`
+	// Seq is taken from the chat's sequence on every create, edit and delete, CreatedSeq only on create
+	Seq        uint64 `gorm:"column:seq; not null; default:0; index:idx_messages_chat_seq,priority:2" json:"seq"`
+	CreatedSeq uint64 `gorm:"column:created_seq; not null; default:0" json:"-"`
+	// ClientMsgID is the idempotency key chosen by the sender, unique per user and chat
+	ClientMsgID *string       `gorm:"column:client_msg_id; uniqueIndex:idx_messages_client_msg_id,priority:3,where:deleted_at IS NULL" json:"clientMsgId,omitempty"`
+	Attachments []*Attachment `gorm:"foreignKey:MessageID" json:"attachments,omitempty"`
+	// ReplyToMessageID is the message of the same chat this one answers, ReplyTo quotes it
+	ReplyToMessageID *uint           `gorm:"column:reply_to_message_id; index" json:"replyToMessageId,omitempty"`
+	ReplyTo          *MessagePreview `gorm:"-" json:"replyTo,omitempty"`
+	// ThreadRootID puts a message into the thread under that root. Thread replies stay out
+	// of the chat timeline unless AlsoSentToChat is set.
+	ThreadRootID   *uint `gorm:"column:thread_root_id" json:"threadRootId,omitempty"`
+	AlsoSentToChat bool  `gorm:"column:also_sent_to_chat; not null; default:false" json:"alsoSentToChat,omitempty"`
+	// ThreadReplyCount and ThreadLastReplyAt are kept on the root by the repository
+	ThreadReplyCount  int        `gorm:"column:thread_reply_count; not null; default:0" json:"threadReplyCount,omitempty"`
+	ThreadLastReplyAt *time.Time `gorm:"column:thread_last_reply_at" json:"threadLastReplyAt,omitempty"`
+	// Reactions are counted for the user who loads the message, they are not stored with it
+	Reactions []*ReactionCount `gorm:"-" json:"reactions,omitempty"`
+}
+
+// InTimeline reports whether the message shows up in the chat, not only in its thread
+func (m *Message) InTimeline() bool {
+	return m.ThreadRootID == nil || m.AlsoSentToChat
+}
+
+// MessagePreview is the part of a quoted message a reply shows. The text of a deleted
+// message is not kept.
+type MessagePreview struct {
+	MessageID      uint   `json:"messageId"`
+	UserID         uint   `json:"userId"`
+	AuthorName     string `json:"authorName"`
+	Text           string `json:"text"`
+	HasAttachments bool   `json:"hasAttachments"`
+	Deleted        bool   `json:"deleted"`
+}
+
+// ThreadSummary is what the chat timeline shows of a thread under its root message
+type ThreadSummary struct {
+	ChatID        uint       `json:"chatId"`
+	RootMessageID uint       `json:"rootMessageId"`
+	ReplyCount    int        `json:"replyCount"`
+	LastReplyAt   *time.Time `json:"lastReplyAt"`
 }
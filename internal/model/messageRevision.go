@@ -0,0 +1,10 @@
+package model
+
+import "gorm.io/gorm"
+
+// MessageRevision keeps the text a message had before one of its edits
+type MessageRevision struct {
+	gorm.Model
+	MessageID uint   `gorm:"column:message_id; not null; index" json:"messageId"`
+	Text      string `gorm:"column:text; not null" json:"text"`
+}
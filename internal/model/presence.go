@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// Presence is what other users may know about whether someone is around.
+// LastSeenAt is nil while the user is online or when they chose to hide it.
+type Presence struct {
+	UserID     uint       `json:"userId"`
+	Online     bool       `json:"online"`
+	LastSeenAt *time.Time `json:"lastSeenAt"`
+}
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// Reaction is one emoji a user put on a message, a user can put several different ones
+type Reaction struct {
+	MessageID uint      `gorm:"column:message_id; primaryKey" json:"messageId"`
+	UserID    uint      `gorm:"column:user_id; primaryKey" json:"userId"`
+	Emoji     string    `gorm:"column:emoji; primaryKey; size:64" json:"emoji"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ReactionCount is how many users reacted to a message with an emoji. Reacted tells
+// whether the user the message was loaded for is one of them.
+type ReactionCount struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+// ReactionUpdate announces that a user added or removed a reaction, Count is the new total for the emoji
+type ReactionUpdate struct {
+	MessageID uint   `json:"messageId"`
+	ChatID    uint   `json:"chatId"`
+	UserID    uint   `json:"userId"`
+	Emoji     string `json:"emoji"`
+	Added     bool   `json:"added"`
+	Count     int    `json:"count"`
+}
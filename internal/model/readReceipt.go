@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// ReadReceipt tells up to which message a participant has read a chat
+type ReadReceipt struct {
+	ChatID            uint      `json:"chatId"`
+	UserID            uint      `json:"userId"`
+	LastReadMessageID uint      `json:"lastReadMessageId"`
+	ReadAt            time.Time `json:"readAt"`
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+type Session struct {
+	gorm.Model
+	UserID            uint       `gorm:"column:user_id; not null; index" json:"userId"`
+	RefreshTokenHash  string     `gorm:"column:refresh_token_hash; not null; uniqueIndex" json:"-"`
+	PreviousTokenHash string     `gorm:"column:previous_token_hash; index" json:"-"`
+	ExpiresAt         time.Time  `gorm:"column:expires_at; not null" json:"expiresAt"`
+	RevokedAt         *time.Time `gorm:"column:revoked_at" json:"revokedAt"`
+}
+
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
@@ -1,9 +1,16 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"time"
+)
 
 type User struct {
 	gorm.Model
-	Login string `gorm:"column:login; not null; unique" json:"login"`
-	Name  string `gorm:"column:name; not null" json:"name"`
+	Login        string `gorm:"column:login; not null; unique" json:"login"`
+	Name         string `gorm:"column:name; not null" json:"name"`
+	PasswordHash string `gorm:"column:password_hash; not null; default:''" json:"-"`
+	// LastSeenAt is only exposed through presence, which respects HideLastSeen
+	LastSeenAt   *time.Time `gorm:"column:last_seen_at" json:"-"`
+	HideLastSeen bool       `gorm:"column:hide_last_seen; not null; default:false" json:"-"`
 }
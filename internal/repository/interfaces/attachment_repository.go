@@ -0,0 +1,25 @@
+package interfaces
+
+import (
+	"errors"
+	"simpleMessenger/internal/model"
+	"time"
+)
+
+var (
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	// ErrAttachmentUnavailable means an upload is gone, not the author's or on another message already
+	ErrAttachmentUnavailable = errors.New("attachment is not available for linking")
+)
+
+type AttachmentRepo interface {
+	Create(attachment *model.Attachment) error
+	GetByID(id uint) (*model.Attachment, error)
+	GetByIDs(ids []uint) ([]*model.Attachment, error)
+	// CountUploadedSince sums the uploads of a user after since, deleted ones included
+	CountUploadedSince(uploaderID uint, since time.Time) (count int64, size int64, err error)
+	// GetOrphaned returns attachments nobody can see anymore: those of deleted messages or
+	// chats, and uploads that were never sent and are older than unlinkedBefore
+	GetOrphaned(unlinkedBefore time.Time, limit int) ([]*model.Attachment, error)
+	Delete(id uint) error
+}
@@ -7,15 +7,35 @@ import (
 
 var (
 	ErrChatParticipantsNotFound = errors.New("chat participants not found")
+	ErrNotChatOwner             = errors.New("participant is not the chat owner")
 )
 
 type ChatParticipantsRepo interface {
 	Create(chatParticipants *model.ChatParticipants) error
+	CreateBatch(chatParticipants []*model.ChatParticipants) error
 	GetByID(id uint) (*model.ChatParticipants, error)
 	GetChatParticipantsByChatID(ChatId uint) ([]uint, error)
+	GetParticipantsByChatID(chatID uint) ([]*model.ChatParticipants, error)
+	// GetCoParticipants returns every other user that shares at least one chat with userID
+	GetCoParticipants(userID uint) ([]uint, error)
+	// SharesChat reports whether two users are both in at least one chat
+	SharesChat(userID, otherUserID uint) (bool, error)
 	IsChatExists(firstUserID, secondUserID uint) (bool, error)
 	IsUserInChat(userID, chatID uint) (bool, error)
+	GetParticipant(userID, chatID uint) (*model.ChatParticipants, error)
+	CountParticipants(chatID uint) (int64, error)
+	// AdvanceLastDelivered and AdvanceLastRead move a pointer to messageID and report false
+	// if it was already there or further
+	AdvanceLastDelivered(userID, chatID, messageID uint) (bool, error)
+	AdvanceLastRead(userID, chatID, messageID uint) (bool, error)
+	GetReaders(chatID, messageID uint) ([]*model.ReadReceipt, error)
 	Update(participants *model.ChatParticipants) error
+	// TransferOwnership makes toUserID the owner and fromUserID an admin in one go. It fails
+	// with ErrNotChatOwner if fromUserID is not the owner (anymore).
+	TransferOwnership(chatID, fromUserID, toUserID uint) error
+	// Leave removes userID from the chat and returns how many participants are left. A
+	// leaving owner hands the chat to the oldest admin, or the oldest member if there is none.
+	Leave(userID, chatID uint) (int64, error)
 	Delete(id uint) error
 	DeleteChat(chatID uint) error
 }
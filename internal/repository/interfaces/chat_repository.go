@@ -3,6 +3,7 @@ package interfaces
 import (
 	"errors"
 	"simpleMessenger/internal/model"
+	"time"
 )
 
 var (
@@ -10,10 +11,23 @@ var (
 	ErrChatAlreadyExists = errors.New("chat already exists")
 )
 
+// ChatCursor points at a chat by its position in the (last_message_at, id) ordering of a chat list
+type ChatCursor struct {
+	LastMessageAt time.Time
+	ID            uint
+}
+
+// ChatPageQuery selects chats that come strictly after Before in the newest-first chat list
+type ChatPageQuery struct {
+	Before *ChatCursor
+	Limit  int
+}
+
 type ChatRepo interface {
 	Create(chat *model.Chat) error
 	GetByID(id uint) (*model.Chat, error)
 	GetChats(userID uint, limit int) ([]*model.Chat, error)
+	GetChatSummaries(userID uint, query ChatPageQuery) ([]*model.ChatSummary, error)
 	Update(chat *model.Chat) error
 	Delete(id uint) error
 }
@@ -0,0 +1,10 @@
+package interfaces
+
+import (
+	"simpleMessenger/internal/model"
+)
+
+type MessageRevisionRepo interface {
+	Create(revision *model.MessageRevision) error
+	GetByMessageID(messageID uint) ([]*model.MessageRevision, error)
+}
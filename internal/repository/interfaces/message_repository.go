@@ -3,17 +3,50 @@ package interfaces
 import (
 	"errors"
 	"simpleMessenger/internal/model"
+	"time"
 )
 
 var (
-	ErrMessageNotFound = errors.New("message not found")
+	ErrMessageNotFound      = errors.New("message not found")
+	ErrMessageAlreadyExists = errors.New("message with this client id already exists")
 )
 
+// MessageCursor points at a message by its position in the (created_at, id) ordering of a chat
+type MessageCursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
+
+// MessagePageQuery selects messages strictly older than Before or strictly newer than After.
+// Without a cursor the newest messages are returned. Results are always in ascending order.
+// Without ThreadRootID the chat timeline is paged, which leaves out replies posted only to
+// their thread; with it the replies of that thread.
+type MessagePageQuery struct {
+	Before       *MessageCursor
+	After        *MessageCursor
+	Limit        int
+	ThreadRootID uint
+}
+
 type MessageRepo interface {
-	Create(message *model.Message) error
+	// Create stores a message and, in the same transaction, links the unlinked uploads of its
+	// author in attachmentIDs to it. It fails with ErrAttachmentUnavailable unless all of them
+	// could be linked. A message in the chat timeline also becomes the chat's last message.
+	Create(message *model.Message, attachmentIDs []uint) error
 	GetByID(id uint) (*model.Message, error)
-	GetMessagesByChatID(chatID uint, limit int) ([]*model.Message, error)
+	GetByClientMsgID(userID, chatID uint, clientMsgID string) (*model.Message, error)
+	GetMessagesByChatID(chatID uint, query MessagePageQuery) ([]*model.Message, error)
+	// GetChangedSince returns messages of a chat, deleted ones included, changed after seq in sequence order
+	GetChangedSince(chatID uint, seq uint64, limit int) ([]*model.Message, error)
+	// GetPreviews describes messages for quoting them, deleted ones included
+	GetPreviews(ids []uint) ([]*model.MessagePreview, error)
+	// GetThreadParticipants returns the author of a thread root and everyone who replied in the thread
+	GetThreadParticipants(rootID uint) ([]uint, error)
+	// Update leaves the thread counters of a root alone, they are maintained on create and delete
 	Update(message *model.Message) error
+	// Edit replaces the text of a message with message.Text and sets its EditedAt. The text it
+	// had, read under a row lock, is kept as a revision in the same transaction.
+	Edit(message *model.Message) error
 	Delete(id uint) error
 	DeleteAllMessagesInChat(chatID uint) error
 }
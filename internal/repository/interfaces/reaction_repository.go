@@ -0,0 +1,23 @@
+package interfaces
+
+import (
+	"errors"
+	"simpleMessenger/internal/model"
+)
+
+var (
+	ErrTooManyReactions = errors.New("user has too many reactions on message")
+)
+
+// ReactionRepo gives the message a new sequence number of its chat on every change, so
+// clients that sync pick reactions up like an edit
+type ReactionRepo interface {
+	// Add stores a reaction and reports false if the user had already reacted with that emoji.
+	// It fails with ErrTooManyReactions if the user has maxPerUser reactions on the message.
+	Add(reaction *model.Reaction, chatID uint, maxPerUser int) (bool, error)
+	// Remove reports false if there was no such reaction
+	Remove(messageID, chatID, userID uint, emoji string) (bool, error)
+	CountByEmoji(messageID uint, emoji string) (int, error)
+	// CountByMessageIDs aggregates reactions per message, emojis in the order they were first used
+	CountByMessageIDs(messageIDs []uint, viewerID uint) (map[uint][]*model.ReactionCount, error)
+}
@@ -0,0 +1,22 @@
+package interfaces
+
+import (
+	"errors"
+	"simpleMessenger/internal/model"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+type SessionRepo interface {
+	Create(session *model.Session) error
+	GetByID(id uint) (*model.Session, error)
+	GetByRefreshTokenHash(hash string) (*model.Session, error)
+	GetByPreviousTokenHash(hash string) (*model.Session, error)
+	// Rotate stores the new refresh token of a session, provided it still has the one with
+	// previousHash and isn't revoked. Otherwise it fails with ErrSessionNotFound.
+	Rotate(session *model.Session, previousHash string) error
+	Revoke(id uint) error
+	RevokeAllByUserID(userID uint) error
+}
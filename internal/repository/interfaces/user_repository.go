@@ -3,6 +3,7 @@ package interfaces
 import (
 	"errors"
 	"simpleMessenger/internal/model"
+	"time"
 )
 
 var (
@@ -17,5 +18,7 @@ type UserRepo interface {
 	GetByLogin(login string) (*model.User, error)
 	SearchByLogin(login string, limit int) ([]*model.User, error)
 	Update(user *model.User) error
+	UpdateLastSeen(id uint, lastSeenAt time.Time) error
+	SetHideLastSeen(id uint, hide bool) error
 	Delete(id uint) error
 }
@@ -0,0 +1,108 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"simpleMessenger/internal/model"
+	repoInterfaces "simpleMessenger/internal/repository/interfaces"
+	"time"
+)
+
+type attachmentRepository struct {
+	db *gorm.DB
+}
+
+func NewAttachmentRepository(db *gorm.DB) repoInterfaces.AttachmentRepo {
+	return &attachmentRepository{db: db}
+}
+
+func (r *attachmentRepository) Create(attachment *model.Attachment) error {
+	result := r.db.Create(attachment)
+	if result.Error != nil {
+		return fmt.Errorf("create attachment: %w", result.Error)
+	}
+	return nil
+}
+
+func (r *attachmentRepository) GetByID(id uint) (*model.Attachment, error) {
+	attachment := &model.Attachment{}
+	err := r.db.Where("id = ?", id).First(attachment).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, repoInterfaces.ErrAttachmentNotFound
+		}
+		return nil, fmt.Errorf("get attachment by id: %w", err)
+	}
+	return attachment, nil
+}
+
+func (r *attachmentRepository) GetByIDs(ids []uint) ([]*model.Attachment, error) {
+	var attachments []*model.Attachment
+
+	err := r.db.Where("id IN ?", ids).Order("id ASC").Find(&attachments).Error
+	if err != nil {
+		return nil, fmt.Errorf("get attachments by ids: %w", err)
+	}
+
+	return attachments, nil
+}
+
+func (r *attachmentRepository) CountUploadedSince(uploaderID uint, since time.Time) (int64, int64, error) {
+	var usage struct {
+		Count int64
+		Size  int64
+	}
+
+	// deleting an upload must not give the quota back
+	err := r.db.Unscoped().Model(&model.Attachment{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(size), 0) AS size").
+		Where("uploader_id = ? AND created_at > ?", uploaderID, since).
+		Scan(&usage).Error
+	if err != nil {
+		return 0, 0, fmt.Errorf("count uploaded attachments: %w", err)
+	}
+
+	return usage.Count, usage.Size, nil
+}
+
+func (r *attachmentRepository) GetOrphaned(unlinkedBefore time.Time, limit int) ([]*model.Attachment, error) {
+	var attachments []*model.Attachment
+
+	deletedMessages := r.db.Unscoped().Model(&model.Message{}).Select("id").Where("deleted_at IS NOT NULL")
+	deletedChats := r.db.Unscoped().Model(&model.Chat{}).Select("id").Where("deleted_at IS NOT NULL")
+	err := r.db.
+		Where("(message_id IS NULL AND created_at < ?) OR message_id IN (?) OR chat_id IN (?)", unlinkedBefore, deletedMessages, deletedChats).
+		Order("id ASC").
+		Limit(limit).
+		Find(&attachments).Error
+	if err != nil {
+		return nil, fmt.Errorf("get orphaned attachments: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// linkAttachments attaches unlinked uploads of uploaderID to a message, it runs in the
+// transaction that creates the message
+func linkAttachments(tx *gorm.DB, ids []uint, messageID, uploaderID uint) error {
+	result := tx.Model(&model.Attachment{}).
+		Where("id IN ? AND uploader_id = ? AND message_id IS NULL", ids, uploaderID).
+		Update("message_id", messageID)
+	if result.Error != nil {
+		return fmt.Errorf("link attachments to message: %w", result.Error)
+	}
+	// another message took some of the attachments in the meantime
+	if result.RowsAffected != int64(len(ids)) {
+		return repoInterfaces.ErrAttachmentUnavailable
+	}
+	return nil
+}
+
+func (r *attachmentRepository) Delete(id uint) error {
+	result := r.db.Delete(&model.Attachment{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("delete attachment: %w", result.Error)
+	}
+	return nil
+}
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"simpleMessenger/internal/model"
 	repoInterfaces "simpleMessenger/internal/repository/interfaces"
+	"time"
 )
 
 type chatParticipantsRepository struct {
@@ -24,6 +26,18 @@ func (c *chatParticipantsRepository) Create(chatParticipants *model.ChatParticip
 	return nil
 }
 
+func (c *chatParticipantsRepository) CreateBatch(chatParticipants []*model.ChatParticipants) error {
+	if len(chatParticipants) == 0 {
+		return nil
+	}
+
+	result := c.db.Create(chatParticipants)
+	if result.Error != nil {
+		return fmt.Errorf("create chatParticipants batch: %w", result.Error)
+	}
+	return nil
+}
+
 func (c *chatParticipantsRepository) GetByID(id uint) (*model.ChatParticipants, error) {
 	chatParticipants := &model.ChatParticipants{}
 	result := c.db.Where("id = ?", id).First(chatParticipants)
@@ -47,9 +61,50 @@ func (c *chatParticipantsRepository) GetChatParticipantsByChatID(chatId uint) ([
 	return chatParticipants, nil
 }
 
+func (c *chatParticipantsRepository) GetParticipantsByChatID(chatID uint) ([]*model.ChatParticipants, error) {
+	var participants []*model.ChatParticipants
+	err := c.db.Where("chat_id = ?", chatID).Order("id ASC").Find(&participants).Error
+	if err != nil {
+		return nil, fmt.Errorf("get participants by chat id: %w", err)
+	}
+
+	return participants, nil
+}
+
+func (c *chatParticipantsRepository) GetCoParticipants(userID uint) ([]uint, error) {
+	var userIDs []uint
+
+	userChats := c.db.Model(&model.ChatParticipants{}).Select("chat_id").Where("user_id = ?", userID)
+	err := c.db.Model(&model.ChatParticipants{}).
+		Distinct("user_id").
+		Where("chat_id IN (?) AND user_id <> ?", userChats, userID).
+		Pluck("user_id", &userIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("get co-participants: %w", err)
+	}
+
+	return userIDs, nil
+}
+
+func (c *chatParticipantsRepository) SharesChat(userID, otherUserID uint) (bool, error) {
+	var count int64
+
+	userChats := c.db.Model(&model.ChatParticipants{}).Select("chat_id").Where("user_id = ?", userID)
+	err := c.db.Model(&model.ChatParticipants{}).
+		Where("chat_id IN (?) AND user_id = ?", userChats, otherUserID).
+		Limit(1).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("check shared chat: %w", err)
+	}
+
+	return count > 0, nil
+}
+
 func (c *chatParticipantsRepository) IsChatExists(firstUserID, secondUserID uint) (bool, error) {
 	var chatID uint
-	err := c.db.Model(&model.ChatParticipants{}).Select("chat_id").Where("user_id IN (?, ?)", firstUserID, secondUserID).Group("chat_id").Having("COUNT(DISTINCT user_id) = ?", 2).Having("COUNT(*) = ?", 2).Having("(SELECT COUNT(*) FROM chat_participants WHERE chat_id = chat_participants.chat_id) = ?", 2).Take(&chatID).Error
+	directChats := c.db.Model(&model.Chat{}).Select("id").Where("is_group = ?", false)
+	err := c.db.Model(&model.ChatParticipants{}).Select("chat_id").Where("user_id IN (?, ?)", firstUserID, secondUserID).Where("chat_id IN (?)", directChats).Group("chat_id").Having("COUNT(DISTINCT user_id) = ?", 2).Having("COUNT(*) = ?", 2).Having("(SELECT COUNT(*) FROM chat_participants WHERE chat_id = chat_participants.chat_id) = ?", 2).Take(&chatID).Error
 
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -73,17 +128,182 @@ func (c *chatParticipantsRepository) IsUserInChat(userID, chatID uint) (bool, er
 	return true, nil
 }
 
+func (c *chatParticipantsRepository) GetParticipant(userID, chatID uint) (*model.ChatParticipants, error) {
+	participant := &model.ChatParticipants{}
+	err := c.db.Where("user_id = ? AND chat_id = ?", userID, chatID).First(participant).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, repoInterfaces.ErrChatParticipantsNotFound
+		}
+		return nil, fmt.Errorf("get chat participant: %w", err)
+	}
+
+	return participant, nil
+}
+
+func (c *chatParticipantsRepository) CountParticipants(chatID uint) (int64, error) {
+	var count int64
+	err := c.db.Model(&model.ChatParticipants{}).Where("chat_id = ?", chatID).Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("count chat participants: %w", err)
+	}
+
+	return count, nil
+}
+
+func (c *chatParticipantsRepository) AdvanceLastDelivered(userID, chatID, messageID uint) (bool, error) {
+	result := c.db.Model(&model.ChatParticipants{}).
+		Where("user_id = ? AND chat_id = ?", userID, chatID).
+		Where("last_delivered_message_id IS NULL OR last_delivered_message_id < ?", messageID).
+		Updates(map[string]interface{}{
+			"last_delivered_message_id": messageID,
+			"last_delivered_at":         time.Now(),
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("advance last delivered message: %w", result.Error)
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+func (c *chatParticipantsRepository) AdvanceLastRead(userID, chatID, messageID uint) (bool, error) {
+	result := c.db.Model(&model.ChatParticipants{}).
+		Where("user_id = ? AND chat_id = ?", userID, chatID).
+		Where("last_read_message_id IS NULL OR last_read_message_id < ?", messageID).
+		Updates(map[string]interface{}{
+			"last_read_message_id": messageID,
+			"last_read_at":         time.Now(),
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("advance last read message: %w", result.Error)
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+func (c *chatParticipantsRepository) GetReaders(chatID, messageID uint) ([]*model.ReadReceipt, error) {
+	var receipts []*model.ReadReceipt
+
+	err := c.db.Model(&model.ChatParticipants{}).
+		Select("chat_id, user_id, last_read_message_id, last_read_at AS read_at").
+		Where("chat_id = ? AND last_read_message_id >= ?", chatID, messageID).
+		Order("last_read_at ASC").
+		Scan(&receipts).Error
+	if err != nil {
+		return nil, fmt.Errorf("get message readers: %w", err)
+	}
+
+	return receipts, nil
+}
+
 func (c *chatParticipantsRepository) Update(participants *model.ChatParticipants) error {
-	result := c.db.Model(&model.ChatParticipants{}).Updates(participants)
+	result := c.db.Model(&model.ChatParticipants{}).Where("id = ?", participants.ID).Updates(participants)
 	if result.Error != nil {
-		if result.RowsAffected == 0 {
+		return fmt.Errorf("update chatParticipants: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return repoInterfaces.ErrChatParticipantsNotFound
+	}
+	return nil
+}
+
+// lockParticipants serializes changes to who is in a chat and with which role until tx ends
+func lockParticipants(tx *gorm.DB, chatID uint) ([]*model.ChatParticipants, error) {
+	var participants []*model.ChatParticipants
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("chat_id = ?", chatID).Order("id ASC").Find(&participants).Error
+	if err != nil {
+		return nil, fmt.Errorf("lock chat participants: %w", err)
+	}
+	return participants, nil
+}
+
+func (c *chatParticipantsRepository) TransferOwnership(chatID, fromUserID, toUserID uint) error {
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		participants, err := lockParticipants(tx, chatID)
+		if err != nil {
+			return err
+		}
+
+		var from, to *model.ChatParticipants
+		for _, participant := range participants {
+			switch participant.UserID {
+			case fromUserID:
+				from = participant
+			case toUserID:
+				to = participant
+			}
+		}
+		if from == nil || from.Role != model.ChatRoleOwner {
+			return repoInterfaces.ErrNotChatOwner
+		}
+		if to == nil {
 			return repoInterfaces.ErrChatParticipantsNotFound
 		}
-		return fmt.Errorf("update chatParticipants: %w", result.Error)
+
+		err = tx.Model(&model.ChatParticipants{}).Where("id = ?", from.ID).Update("role", model.ChatRoleAdmin).Error
+		if err != nil {
+			return fmt.Errorf("demote previous owner: %w", err)
+		}
+		return tx.Model(&model.ChatParticipants{}).Where("id = ?", to.ID).Update("role", model.ChatRoleOwner).Error
+	})
+	if err != nil {
+		if errors.Is(err, repoInterfaces.ErrNotChatOwner) || errors.Is(err, repoInterfaces.ErrChatParticipantsNotFound) {
+			return err
+		}
+		return fmt.Errorf("transfer chat ownership: %w", err)
 	}
 	return nil
 }
 
+func (c *chatParticipantsRepository) Leave(userID, chatID uint) (int64, error) {
+	var remaining int64
+
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		participants, err := lockParticipants(tx, chatID)
+		if err != nil {
+			return err
+		}
+
+		var leaving *model.ChatParticipants
+		others := make([]*model.ChatParticipants, 0, len(participants))
+		for _, participant := range participants {
+			if participant.UserID == userID {
+				leaving = participant
+			} else {
+				others = append(others, participant)
+			}
+		}
+		if leaving == nil {
+			return repoInterfaces.ErrChatParticipantsNotFound
+		}
+		remaining = int64(len(others))
+
+		if err := tx.Delete(&model.ChatParticipants{}, leaving.ID).Error; err != nil {
+			return fmt.Errorf("delete chatParticipants: %w", err)
+		}
+
+		if leaving.Role != model.ChatRoleOwner || len(others) == 0 {
+			return nil
+		}
+
+		heir := others[0]
+		for _, participant := range others {
+			if participant.Role == model.ChatRoleAdmin {
+				heir = participant
+				break
+			}
+		}
+		return tx.Model(&model.ChatParticipants{}).Where("id = ?", heir.ID).Update("role", model.ChatRoleOwner).Error
+	})
+	if err != nil {
+		if errors.Is(err, repoInterfaces.ErrChatParticipantsNotFound) {
+			return 0, err
+		}
+		return 0, fmt.Errorf("leave chat: %w", err)
+	}
+	return remaining, nil
+}
+
 func (c *chatParticipantsRepository) Delete(id uint) error {
 	result := c.db.Delete(&model.ChatParticipants{}, id)
 	if result.Error != nil {
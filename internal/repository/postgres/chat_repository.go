@@ -1,11 +1,13 @@
 package postgres
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"gorm.io/gorm"
 	"simpleMessenger/internal/model"
 	repoInterfaces "simpleMessenger/internal/repository/interfaces"
+	"time"
 )
 
 type chatRepository struct {
@@ -40,7 +42,7 @@ func (r *chatRepository) GetChats(userID uint, limit int) ([]*model.Chat, error)
 	var chats []*model.Chat
 
 	query := r.db.
-		Where("id IN (SELECT chat_id FROM chat_participants WHERE user_id = ?)", userID).
+		Where("id IN (SELECT chat_id FROM chat_participants WHERE user_id = ? AND deleted_at IS NULL)", userID).
 		Order("last_message_at DESC")
 
 	if limit > 0 {
@@ -55,8 +57,120 @@ func (r *chatRepository) GetChats(userID uint, limit int) ([]*model.Chat, error)
 	return chats, nil
 }
 
+// chatSummaryQuery builds the whole chat list page in one round trip. Unread messages are the ones
+// after the caller's read pointer; participants that never marked anything read fall back to
+// everything other users wrote after the caller's own last message. Replies posted only to
+// a thread are not part of the timeline and count for neither.
+const chatSummaryQuery = `
+SELECT
+	c.id, c.name, c.is_group, c.last_message_at, c.created_at, c.updated_at,
+	lm.id AS last_message_id,
+	lm.text AS last_message_text,
+	lm.user_id AS last_message_user_id,
+	lm.created_at AS last_message_created_at,
+	lm.edited_at AS last_message_edited_at,
+	(
+		SELECT COUNT(*) FROM messages um
+		WHERE um.chat_id = c.id AND um.deleted_at IS NULL AND um.user_id <> @user
+		AND (um.thread_root_id IS NULL OR um.also_sent_to_chat)
+		AND CASE WHEN me.last_read_message_id IS NOT NULL
+			THEN um.id > me.last_read_message_id
+			ELSE um.created_at > COALESCE(
+				(SELECT MAX(own.created_at) FROM messages own
+				WHERE own.chat_id = c.id AND own.user_id = @user AND own.deleted_at IS NULL),
+				me.created_at
+			)
+		END
+	) AS unread_count,
+	(
+		SELECT COALESCE(json_agg(json_build_object(
+			'userId', u.id, 'login', u.login, 'name', u.name, 'role', p.role
+		) ORDER BY p.id), '[]')
+		FROM chat_participants p JOIN users u ON u.id = p.user_id
+		WHERE p.chat_id = c.id AND p.user_id <> @user AND p.deleted_at IS NULL AND u.deleted_at IS NULL
+	) AS participants
+FROM chats c
+JOIN chat_participants me ON me.chat_id = c.id AND me.user_id = @user AND me.deleted_at IS NULL
+LEFT JOIN LATERAL (
+	SELECT m.id, m.text, m.user_id, m.created_at, m.edited_at FROM messages m
+	WHERE m.chat_id = c.id AND m.deleted_at IS NULL AND (m.thread_root_id IS NULL OR m.also_sent_to_chat)
+	ORDER BY m.created_at DESC, m.id DESC
+	LIMIT 1
+) lm ON TRUE
+WHERE c.deleted_at IS NULL AND (@cursor_id = 0 OR (c.last_message_at, c.id) < (@cursor_time, @cursor_id))
+ORDER BY c.last_message_at DESC, c.id DESC
+LIMIT @limit`
+
+type chatSummaryRow struct {
+	ID                   uint
+	Name                 string
+	IsGroup              bool
+	LastMessageAt        time.Time
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+	LastMessageID        *uint
+	LastMessageText      *string
+	LastMessageUserID    *uint
+	LastMessageCreatedAt *time.Time
+	LastMessageEditedAt  *time.Time
+	UnreadCount          int64
+	Participants         string
+}
+
+func (r *chatRepository) GetChatSummaries(userID uint, query repoInterfaces.ChatPageQuery) ([]*model.ChatSummary, error) {
+	var rows []*chatSummaryRow
+
+	params := map[string]interface{}{
+		"user":        userID,
+		"cursor_id":   uint(0),
+		"cursor_time": time.Time{},
+		"limit":       query.Limit,
+	}
+	if query.Before != nil {
+		params["cursor_id"] = query.Before.ID
+		params["cursor_time"] = query.Before.LastMessageAt
+	}
+
+	err := r.db.Raw(chatSummaryQuery, params).Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("get chat summaries: %w", err)
+	}
+
+	summaries := make([]*model.ChatSummary, 0, len(rows))
+	for _, row := range rows {
+		summary := &model.ChatSummary{
+			Chat: &model.Chat{
+				Model:         gorm.Model{ID: row.ID, CreatedAt: row.CreatedAt, UpdatedAt: row.UpdatedAt},
+				Name:          row.Name,
+				IsGroup:       row.IsGroup,
+				LastMessageAt: row.LastMessageAt,
+			},
+			UnreadCount: row.UnreadCount,
+		}
+
+		if row.LastMessageID != nil {
+			summary.LastMessage = &model.Message{
+				Model:    gorm.Model{ID: *row.LastMessageID, CreatedAt: *row.LastMessageCreatedAt},
+				Text:     *row.LastMessageText,
+				ChatID:   row.ID,
+				UserID:   *row.LastMessageUserID,
+				EditedAt: row.LastMessageEditedAt,
+			}
+		}
+
+		if err := json.Unmarshal([]byte(row.Participants), &summary.Participants); err != nil {
+			return nil, fmt.Errorf("decode chat participants: %w", err)
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, nil
+}
+
 func (r *chatRepository) Update(chat *model.Chat) error {
-	result := r.db.Model(&model.Chat{}).Where("id = ?", chat.ID).Updates(chat)
+	// last_seq is only ever advanced by message writes, a stale copy must not move it back
+	result := r.db.Model(&model.Chat{}).Where("id = ?", chat.ID).Omit("last_seq").Updates(chat)
 	if result.Error != nil {
 		return fmt.Errorf("update chat: %w", result.Error)
 	}
@@ -0,0 +1,35 @@
+package postgres
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"simpleMessenger/internal/model"
+	repoInterfaces "simpleMessenger/internal/repository/interfaces"
+)
+
+type messageRevisionRepository struct {
+	db *gorm.DB
+}
+
+func NewMessageRevisionRepository(db *gorm.DB) repoInterfaces.MessageRevisionRepo {
+	return &messageRevisionRepository{db: db}
+}
+
+func (r *messageRevisionRepository) Create(revision *model.MessageRevision) error {
+	result := r.db.Create(revision)
+	if result.Error != nil {
+		return fmt.Errorf("create message revision: %w", result.Error)
+	}
+	return nil
+}
+
+func (r *messageRevisionRepository) GetByMessageID(messageID uint) ([]*model.MessageRevision, error) {
+	var revisions []*model.MessageRevision
+
+	err := r.db.Where("message_id = ?", messageID).Order("created_at ASC").Find(&revisions).Error
+	if err != nil {
+		return nil, fmt.Errorf("get message revisions: %w", err)
+	}
+
+	return revisions, nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"simpleMessenger/internal/model"
 	repoInterfaces "simpleMessenger/internal/repository/interfaces"
 )
@@ -16,17 +17,88 @@ func NewMessageRepository(db *gorm.DB) repoInterfaces.MessageRepo {
 	return &messageRepository{db: db}
 }
 
-func (r *messageRepository) Create(message *model.Message) error {
-	result := r.db.Create(message)
+// nextSeq advances the sequence of a chat. The chat row stays locked until tx ends,
+// so changes of one chat commit in the order of their sequence numbers.
+func nextSeq(tx *gorm.DB, chatID uint) (uint64, error) {
+	var seq uint64
+	result := tx.Raw("UPDATE chats SET last_seq = last_seq + 1 WHERE id = ? RETURNING last_seq", chatID).Scan(&seq)
 	if result.Error != nil {
-		return fmt.Errorf("create message: %w", result.Error)
+		return 0, fmt.Errorf("advance chat sequence: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return 0, repoInterfaces.ErrChatNotFound
+	}
+	return seq, nil
+}
+
+// refreshThread recounts the replies of a thread on its root. The root gets a new sequence
+// number, so clients that sync see the new counters as an edit.
+func refreshThread(tx *gorm.DB, rootID, chatID uint) error {
+	seq, err := nextSeq(tx, chatID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Exec(`
+		UPDATE messages SET
+			thread_reply_count = (SELECT COUNT(*) FROM messages r WHERE r.thread_root_id = @root AND r.deleted_at IS NULL),
+			thread_last_reply_at = (SELECT MAX(r.created_at) FROM messages r WHERE r.thread_root_id = @root AND r.deleted_at IS NULL),
+			seq = @seq
+		WHERE id = @root`,
+		map[string]interface{}{"root": rootID, "seq": seq}).Error
+	if err != nil {
+		return fmt.Errorf("refresh thread: %w", err)
+	}
+	return nil
+}
+
+func (r *messageRepository) Create(message *model.Message, attachmentIDs []uint) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		seq, err := nextSeq(tx, message.ChatID)
+		if err != nil {
+			return err
+		}
+		message.Seq = seq
+		message.CreatedSeq = seq
+
+		// attachments are uploaded beforehand and only linked here
+		if err := tx.Omit(clause.Associations).Create(message).Error; err != nil {
+			return err
+		}
+
+		if len(attachmentIDs) > 0 {
+			if err := linkAttachments(tx, attachmentIDs, message.ID, message.UserID); err != nil {
+				return err
+			}
+		}
+
+		if message.InTimeline() {
+			err := tx.Model(&model.Chat{}).Where("id = ?", message.ChatID).Update("last_message_at", message.CreatedAt).Error
+			if err != nil {
+				return fmt.Errorf("update last message of chat: %w", err)
+			}
+		}
+
+		if message.ThreadRootID != nil {
+			return refreshThread(tx, *message.ThreadRootID, message.ChatID)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return repoInterfaces.ErrMessageAlreadyExists
+		}
+		if errors.Is(err, repoInterfaces.ErrAttachmentUnavailable) {
+			return err
+		}
+		return fmt.Errorf("create message: %w", err)
 	}
 	return nil
 }
 
 func (r *messageRepository) GetByID(id uint) (*model.Message, error) {
 	message := &model.Message{}
-	err := r.db.Where("id = ?", id).First(message).Error
+	err := r.db.Preload("Attachments").Where("id = ?", id).First(message).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, repoInterfaces.ErrMessageNotFound
@@ -36,54 +108,219 @@ func (r *messageRepository) GetByID(id uint) (*model.Message, error) {
 	return message, nil
 }
 
-func (r *messageRepository) GetMessagesByChatID(chatID uint, limit int) ([]*model.Message, error) {
+func (r *messageRepository) GetByClientMsgID(userID, chatID uint, clientMsgID string) (*model.Message, error) {
+	message := &model.Message{}
+	err := r.db.Preload("Attachments").Where("user_id = ? AND chat_id = ? AND client_msg_id = ?", userID, chatID, clientMsgID).First(message).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, repoInterfaces.ErrMessageNotFound
+		}
+		return nil, fmt.Errorf("get message by client id: %w", err)
+	}
+	return message, nil
+}
+
+func (r *messageRepository) GetMessagesByChatID(chatID uint, query repoInterfaces.MessagePageQuery) ([]*model.Message, error) {
 	var messages []*model.Message
 
-	dbQuery := r.db.Model(&model.Message{}).Where("chat_id = ?", chatID)
+	dbQuery := r.db.Model(&model.Message{}).Preload("Attachments").Where("chat_id = ?", chatID)
 
-	if limit > 0 {
-		subQuery := dbQuery.
-			Order("created_at DESC").
-			Limit(limit).
-			Select("id")
-
-		err := r.db.Model(&model.Message{}).
-			Where("id IN (?)", subQuery).
-			Order("created_at ASC").
-			Find(&messages).Error
-		if err != nil {
-			return nil, fmt.Errorf("get last messages in chat: %w", err)
-		}
+	if query.ThreadRootID != 0 {
+		dbQuery = dbQuery.Where("thread_root_id = ?", query.ThreadRootID)
 	} else {
-		err := dbQuery.
-			Order("created_at ASC").
-			Find(&messages).Error
-		if err != nil {
-			return nil, fmt.Errorf("get all messages in chat: %w", err)
+		dbQuery = dbQuery.Where("(thread_root_id IS NULL OR also_sent_to_chat)")
+	}
+
+	// walking forward from a cursor reads in ascending order, everything else reads the newest rows first
+	ascending := query.After != nil && query.Before == nil
+
+	if query.Before != nil {
+		dbQuery = dbQuery.Where("(created_at, id) < (?, ?)", query.Before.CreatedAt, query.Before.ID)
+	}
+	if query.After != nil {
+		dbQuery = dbQuery.Where("(created_at, id) > (?, ?)", query.After.CreatedAt, query.After.ID)
+	}
+
+	if ascending {
+		dbQuery = dbQuery.Order("created_at ASC, id ASC")
+	} else {
+		dbQuery = dbQuery.Order("created_at DESC, id DESC")
+	}
+
+	if query.Limit > 0 {
+		dbQuery = dbQuery.Limit(query.Limit)
+	}
+
+	err := dbQuery.Find(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("get messages in chat: %w", err)
+	}
+
+	if !ascending {
+		for left, right := 0, len(messages)-1; left < right; left, right = left+1, right-1 {
+			messages[left], messages[right] = messages[right], messages[left]
 		}
 	}
 
 	return messages, nil
 }
 
+func (r *messageRepository) GetChangedSince(chatID uint, seq uint64, limit int) ([]*model.Message, error) {
+	var messages []*model.Message
+
+	query := r.db.Unscoped().
+		Preload("Attachments").
+		Where("chat_id = ? AND seq > ?", chatID, seq).
+		Order("seq ASC")
+
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	err := query.Find(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("get changed messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+func (r *messageRepository) GetPreviews(ids []uint) ([]*model.MessagePreview, error) {
+	var previews []*model.MessagePreview
+
+	err := r.db.Table("messages").
+		Select(`messages.id AS message_id, messages.user_id, users.name AS author_name, messages.text,
+			EXISTS (SELECT 1 FROM attachments WHERE attachments.message_id = messages.id) AS has_attachments,
+			messages.deleted_at IS NOT NULL AS deleted`).
+		Joins("LEFT JOIN users ON users.id = messages.user_id").
+		Where("messages.id IN ?", ids).
+		Scan(&previews).Error
+	if err != nil {
+		return nil, fmt.Errorf("get message previews: %w", err)
+	}
+
+	return previews, nil
+}
+
+func (r *messageRepository) GetThreadParticipants(rootID uint) ([]uint, error) {
+	var userIDs []uint
+
+	err := r.db.Raw(`
+		SELECT user_id FROM messages WHERE id = ?
+		UNION
+		SELECT user_id FROM messages WHERE thread_root_id = ? AND deleted_at IS NULL`,
+		rootID, rootID).Scan(&userIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("get thread participants: %w", err)
+	}
+
+	return userIDs, nil
+}
+
 func (r *messageRepository) Update(message *model.Message) error {
-	result := r.db.Model(&model.Message{}).Updates(message)
-	if result.Error != nil {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		seq, err := nextSeq(tx, message.ChatID)
+		if err != nil {
+			return err
+		}
+		message.Seq = seq
+
+		result := tx.Model(&model.Message{}).Where("id = ?", message.ID).
+			Omit(clause.Associations, "thread_reply_count", "thread_last_reply_at").
+			Updates(message)
+		if result.Error != nil {
+			return result.Error
+		}
 		if result.RowsAffected == 0 {
 			return repoInterfaces.ErrMessageNotFound
 		}
-		return fmt.Errorf("update message: %w", result.Error)
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, repoInterfaces.ErrMessageNotFound) {
+			return err
+		}
+		return fmt.Errorf("update message: %w", err)
+	}
+	return nil
+}
+
+func (r *messageRepository) Edit(message *model.Message) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// the chat is locked before the message, like every other change of a chat does
+		seq, err := nextSeq(tx, message.ChatID)
+		if err != nil {
+			return err
+		}
+
+		current := &model.Message{}
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", message.ID).First(current).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return repoInterfaces.ErrMessageNotFound
+			}
+			return err
+		}
+
+		// a concurrent edit got there first with the same text
+		if current.Text == message.Text {
+			message.Seq = current.Seq
+			message.EditedAt = current.EditedAt
+			return nil
+		}
+
+		err = tx.Create(&model.MessageRevision{MessageID: current.ID, Text: current.Text}).Error
+		if err != nil {
+			return fmt.Errorf("create message revision: %w", err)
+		}
+
+		message.Seq = seq
+		return tx.Model(&model.Message{}).Where("id = ?", message.ID).Updates(map[string]interface{}{
+			"text":      message.Text,
+			"edited_at": message.EditedAt,
+			"seq":       message.Seq,
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, repoInterfaces.ErrMessageNotFound) {
+			return err
+		}
+		return fmt.Errorf("edit message: %w", err)
 	}
 	return nil
 }
 
 func (r *messageRepository) Delete(id uint) error {
-	result := r.db.Delete(&model.Message{}, id)
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		message := &model.Message{}
+		if err := tx.Where("id = ?", id).First(message).Error; err != nil {
+			return err
+		}
+
+		seq, err := nextSeq(tx, message.ChatID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&model.Message{}).Where("id = ?", id).Update("seq", seq).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&model.Message{}, id).Error; err != nil {
+			return err
+		}
+
+		if message.ThreadRootID != nil {
+			return refreshThread(tx, *message.ThreadRootID, message.ChatID)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return repoInterfaces.ErrMessageNotFound
 		}
-		return fmt.Errorf("delete message: %w", result.Error)
+		return fmt.Errorf("delete message: %w", err)
 	}
 	return nil
 }
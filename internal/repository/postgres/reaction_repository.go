@@ -0,0 +1,135 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"simpleMessenger/internal/model"
+	repoInterfaces "simpleMessenger/internal/repository/interfaces"
+)
+
+type reactionRepository struct {
+	db *gorm.DB
+}
+
+func NewReactionRepository(db *gorm.DB) repoInterfaces.ReactionRepo {
+	return &reactionRepository{db: db}
+}
+
+// errNoReactionChange rolls back the sequence number taken for a change that wasn't one
+var errNoReactionChange = errors.New("reaction unchanged")
+
+func (r *reactionRepository) Add(reaction *model.Reaction, chatID uint, maxPerUser int) (bool, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// the chat row stays locked from here, concurrent reactions can't both pass the limit
+		seq, err := nextSeq(tx, chatID)
+		if err != nil {
+			return err
+		}
+
+		var count int64
+		err = tx.Model(&model.Reaction{}).Where("message_id = ? AND user_id = ?", reaction.MessageID, reaction.UserID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(reaction)
+		if result.Error != nil {
+			return result.Error
+		}
+		// an emoji the user already put there is no change, even at the limit
+		if result.RowsAffected == 0 {
+			return errNoReactionChange
+		}
+		if count >= int64(maxPerUser) {
+			return repoInterfaces.ErrTooManyReactions
+		}
+
+		return touchMessage(tx, reaction.MessageID, seq)
+	})
+	if err != nil {
+		if errors.Is(err, errNoReactionChange) {
+			return false, nil
+		}
+		if errors.Is(err, repoInterfaces.ErrTooManyReactions) {
+			return false, err
+		}
+		return false, fmt.Errorf("add reaction: %w", err)
+	}
+	return true, nil
+}
+
+func (r *reactionRepository) Remove(messageID, chatID, userID uint, emoji string) (bool, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		seq, err := nextSeq(tx, chatID)
+		if err != nil {
+			return err
+		}
+
+		result := tx.Where("message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji).Delete(&model.Reaction{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errNoReactionChange
+		}
+
+		return touchMessage(tx, messageID, seq)
+	})
+	if err != nil {
+		if errors.Is(err, errNoReactionChange) {
+			return false, nil
+		}
+		return false, fmt.Errorf("remove reaction: %w", err)
+	}
+	return true, nil
+}
+
+// touchMessage moves a message to seq without changing it otherwise
+func touchMessage(tx *gorm.DB, messageID uint, seq uint64) error {
+	return tx.Model(&model.Message{}).Where("id = ?", messageID).UpdateColumn("seq", seq).Error
+}
+
+func (r *reactionRepository) CountByEmoji(messageID uint, emoji string) (int, error) {
+	var count int64
+	err := r.db.Model(&model.Reaction{}).Where("message_id = ? AND emoji = ?", messageID, emoji).Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("count reactions: %w", err)
+	}
+	return int(count), nil
+}
+
+func (r *reactionRepository) CountByMessageIDs(messageIDs []uint, viewerID uint) (map[uint][]*model.ReactionCount, error) {
+	counts := make(map[uint][]*model.ReactionCount)
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		MessageID uint
+		Emoji     string
+		Count     int
+		Reacted   bool
+	}
+
+	err := r.db.Model(&model.Reaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, BOOL_OR(user_id = ?) AS reacted", viewerID).
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, emoji").
+		Order("message_id ASC, MIN(created_at) ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("count reactions by messages: %w", err)
+	}
+
+	for _, row := range rows {
+		counts[row.MessageID] = append(counts[row.MessageID], &model.ReactionCount{
+			Emoji:   row.Emoji,
+			Count:   row.Count,
+			Reacted: row.Reacted,
+		})
+	}
+
+	return counts, nil
+}
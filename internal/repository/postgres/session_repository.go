@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"simpleMessenger/internal/model"
+	repoInterfaces "simpleMessenger/internal/repository/interfaces"
+	"time"
+)
+
+type sessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) repoInterfaces.SessionRepo {
+	return &sessionRepository{db: db}
+}
+
+func (r *sessionRepository) Create(session *model.Session) error {
+	result := r.db.Create(session)
+	if result.Error != nil {
+		return fmt.Errorf("create session: %w", result.Error)
+	}
+	return nil
+}
+
+func (r *sessionRepository) GetByID(id uint) (*model.Session, error) {
+	session := &model.Session{}
+	err := r.db.Where("id = ?", id).First(session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, repoInterfaces.ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("get session by id: %w", err)
+	}
+	return session, nil
+}
+
+func (r *sessionRepository) GetByRefreshTokenHash(hash string) (*model.Session, error) {
+	session := &model.Session{}
+	err := r.db.Where("refresh_token_hash = ?", hash).First(session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, repoInterfaces.ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("get session by refresh token: %w", err)
+	}
+	return session, nil
+}
+
+func (r *sessionRepository) GetByPreviousTokenHash(hash string) (*model.Session, error) {
+	session := &model.Session{}
+	err := r.db.Where("previous_token_hash = ?", hash).First(session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, repoInterfaces.ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("get session by previous refresh token: %w", err)
+	}
+	return session, nil
+}
+
+func (r *sessionRepository) Rotate(session *model.Session, previousHash string) error {
+	// of two refreshes with the same token only the first one matches
+	result := r.db.Model(&model.Session{}).
+		Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", session.ID, previousHash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":  session.RefreshTokenHash,
+			"previous_token_hash": session.PreviousTokenHash,
+			"expires_at":          session.ExpiresAt,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("rotate session: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return repoInterfaces.ErrSessionNotFound
+	}
+	return nil
+}
+
+func (r *sessionRepository) Revoke(id uint) error {
+	result := r.db.Model(&model.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("revoke session: %w", result.Error)
+	}
+	return nil
+}
+
+func (r *sessionRepository) RevokeAllByUserID(userID uint) error {
+	err := r.db.Model(&model.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("revoke all sessions of user: %w", err)
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"gorm.io/gorm"
 	"simpleMessenger/internal/model"
 	repoInterfaces "simpleMessenger/internal/repository/interfaces"
+	"time"
 )
 
 type userRepository struct {
@@ -74,6 +75,28 @@ func (r *userRepository) Update(user *model.User) error {
 	return nil
 }
 
+func (r *userRepository) UpdateLastSeen(id uint, lastSeenAt time.Time) error {
+	result := r.db.Model(&model.User{}).Where("id = ?", id).Update("last_seen_at", lastSeenAt)
+	if result.Error != nil {
+		return fmt.Errorf("update user last seen: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return repoInterfaces.ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *userRepository) SetHideLastSeen(id uint, hide bool) error {
+	result := r.db.Model(&model.User{}).Where("id = ?", id).Update("hide_last_seen", hide)
+	if result.Error != nil {
+		return fmt.Errorf("update user last seen visibility: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return repoInterfaces.ErrUserNotFound
+	}
+	return nil
+}
+
 func (r *userRepository) Delete(id uint) error {
 	result := r.db.Delete(&model.User{}, id)
 	if result.Error != nil {
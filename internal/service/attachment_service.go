@@ -0,0 +1,361 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"simpleMessenger/internal/model"
+	repoInterfaces "simpleMessenger/internal/repository/interfaces"
+	"simpleMessenger/internal/storage"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MaxAttachmentSize        = 25 << 20
+	MaxAttachmentsPerMessage = 10
+	maxFileNameLength        = 255
+	// sniffLength is how much of a file http.DetectContentType looks at
+	sniffLength = 512
+
+	// a user may upload this much per uploadQuotaWindow, the last file may go over the size
+	maxUploadsPerWindow    = 100
+	maxUploadSizePerWindow = 500 << 20
+	uploadQuotaWindow      = time.Hour
+
+	// attachmentCleanupInterval is how often blobs nobody can see anymore are deleted
+	attachmentCleanupInterval = 10 * time.Minute
+	// unlinkedAttachmentTTL is how long an upload may wait for the message it was made for
+	unlinkedAttachmentTTL  = 24 * time.Hour
+	attachmentCleanupBatch = 100
+)
+
+// allowedMimeTypes are detected from the content, the type claimed by the uploader is ignored.
+// Anything a browser could run as a page, like html or svg, is deliberately missing.
+var allowedMimeTypes = map[string]bool{
+	"image/png":          true,
+	"image/jpeg":         true,
+	"image/gif":          true,
+	"image/webp":         true,
+	"application/pdf":    true,
+	"text/plain":         true,
+	"application/zip":    true,
+	"application/x-gzip": true,
+}
+
+var (
+	ErrAttachmentTooLarge       = fmt.Errorf("attachment must be at most %d bytes", MaxAttachmentSize)
+	ErrAttachmentTypeNotAllowed = errors.New("attachment type is not allowed")
+	ErrTooManyAttachments       = fmt.Errorf("a message can have at most %d attachments", MaxAttachmentsPerMessage)
+	ErrInvalidAttachment        = errors.New("attachment does not exist or cannot be used")
+	ErrNoThumbnail              = errors.New("attachment has no thumbnail")
+	ErrUploadQuotaExceeded      = errors.New("too many uploads, try again later")
+)
+
+type AttachmentService struct {
+	attachmentRepo repoInterfaces.AttachmentRepo
+	messageRepo    repoInterfaces.MessageRepo
+	blobs          storage.BlobStore
+	permissions    *permissionChecker
+}
+
+func NewAttachmentService(attachmentRepo repoInterfaces.AttachmentRepo, messageRepo repoInterfaces.MessageRepo, blobs storage.BlobStore, members *MembershipCache) *AttachmentService {
+	return &AttachmentService{
+		attachmentRepo: attachmentRepo,
+		messageRepo:    messageRepo,
+		blobs:          blobs,
+		permissions:    newPermissionChecker(members),
+	}
+}
+
+type UploadAttachmentRequest struct {
+	ChatID   uint
+	UserID   uint
+	FileName string
+	Content  io.Reader
+}
+
+// Upload stores a file for a message the user is about to send into the chat
+func (s *AttachmentService) Upload(req *UploadAttachmentRequest) (*model.Attachment, error) {
+	if req == nil {
+		return nil, errors.New("nil request")
+	}
+
+	if _, err := s.permissions.Require(req.UserID, req.ChatID, PermSendMessage); err != nil {
+		return nil, fmt.Errorf("cant upload attachment: %w", err)
+	}
+
+	uploads, uploadedSize, err := s.attachmentRepo.CountUploadedSince(req.UserID, time.Now().Add(-uploadQuotaWindow))
+	if err != nil {
+		return nil, fmt.Errorf("cant check upload quota: %w", err)
+	}
+	if uploads >= maxUploadsPerWindow || uploadedSize >= maxUploadSizePerWindow {
+		return nil, ErrUploadQuotaExceeded
+	}
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(req.Content, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("cant read attachment: %w", err)
+	}
+	head = head[:n]
+
+	mimeType, err := detectMimeType(head)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := newStorageKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// one byte over the limit is enough to know the file is too large
+	content := io.LimitReader(io.MultiReader(bytes.NewReader(head), req.Content), MaxAttachmentSize+1)
+	size, err := s.blobs.Put(key, content)
+	if err != nil {
+		return nil, fmt.Errorf("cant store attachment: %w", err)
+	}
+
+	if size > MaxAttachmentSize {
+		_ = s.blobs.Delete(key)
+		return nil, ErrAttachmentTooLarge
+	}
+
+	attachment := &model.Attachment{
+		ChatID:     req.ChatID,
+		UploaderID: req.UserID,
+		FileName:   sanitizeFileName(req.FileName),
+		MimeType:   mimeType,
+		Size:       size,
+		StorageKey: key,
+	}
+
+	if isImageMimeType(mimeType) {
+		s.describeImage(attachment)
+	}
+
+	err = s.attachmentRepo.Create(attachment)
+	if err != nil {
+		_ = s.blobs.Delete(key)
+		if attachment.ThumbnailKey != "" {
+			_ = s.blobs.Delete(attachment.ThumbnailKey)
+		}
+		return nil, fmt.Errorf("cant save attachment: %w", err)
+	}
+
+	attachment.HasThumbnail = attachment.ThumbnailKey != ""
+	return attachment, nil
+}
+
+// describeImage records the dimensions of a stored image and stores a thumbnail for it.
+// An image that can't be decoded is still a valid attachment, just without either.
+func (s *AttachmentService) describeImage(attachment *model.Attachment) {
+	content, err := s.blobs.Open(attachment.StorageKey)
+	if err != nil {
+		log.Printf("cant open image %s: %v", attachment.StorageKey, err)
+		return
+	}
+	info, err := readImageInfo(content)
+	content.Close()
+	if err != nil {
+		log.Printf("cant describe image %s: %v", attachment.StorageKey, err)
+		return
+	}
+
+	attachment.Width, attachment.Height = info.displaySize()
+
+	content, err = s.blobs.Open(attachment.StorageKey)
+	if err != nil {
+		log.Printf("cant open image %s: %v", attachment.StorageKey, err)
+		return
+	}
+	thumb, err := makeThumbnail(content, info, attachment.MimeType)
+	content.Close()
+	if err != nil {
+		log.Printf("cant make thumbnail for %s: %v", attachment.StorageKey, err)
+		return
+	}
+
+	thumbnailKey := attachment.StorageKey + "-thumb"
+	if _, err := s.blobs.Put(thumbnailKey, bytes.NewReader(thumb.data)); err != nil {
+		log.Printf("cant store thumbnail for %s: %v", attachment.StorageKey, err)
+		return
+	}
+
+	attachment.ThumbnailKey = thumbnailKey
+	attachment.ThumbnailMimeType = thumb.mimeType
+	attachment.ThumbnailWidth = thumb.width
+	attachment.ThumbnailHeight = thumb.height
+}
+
+// RunCleanup deletes the blobs of attachments nobody can see anymore and blocks forever
+func (s *AttachmentService) RunCleanup() {
+	ticker := time.NewTicker(attachmentCleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.cleanup(time.Now()); err != nil {
+			log.Printf("failed to clean up attachments: %v", err)
+		}
+	}
+}
+
+func (s *AttachmentService) cleanup(now time.Time) error {
+	for {
+		attachments, err := s.attachmentRepo.GetOrphaned(now.Add(-unlinkedAttachmentTTL), attachmentCleanupBatch)
+		if err != nil {
+			return err
+		}
+
+		for _, attachment := range attachments {
+			if err := s.deleteAttachment(attachment); err != nil {
+				return err
+			}
+		}
+
+		if len(attachments) < attachmentCleanupBatch {
+			return nil
+		}
+	}
+}
+
+// deleteAttachment removes the blobs before the row, so a failed attempt is retried
+func (s *AttachmentService) deleteAttachment(attachment *model.Attachment) error {
+	if err := s.blobs.Delete(attachment.StorageKey); err != nil {
+		return fmt.Errorf("cant delete attachment blob: %w", err)
+	}
+	if attachment.ThumbnailKey != "" {
+		if err := s.blobs.Delete(attachment.ThumbnailKey); err != nil {
+			return fmt.Errorf("cant delete thumbnail blob: %w", err)
+		}
+	}
+
+	if err := s.attachmentRepo.Delete(attachment.ID); err != nil {
+		return fmt.Errorf("cant delete attachment: %w", err)
+	}
+	return nil
+}
+
+// Open returns an attachment with its content if the user may see it
+func (s *AttachmentService) Open(attachmentID, userID uint) (*model.Attachment, io.ReadCloser, error) {
+	attachment, err := s.getVisible(attachmentID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, err := s.openBlob(attachment.StorageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return attachment, content, nil
+}
+
+// OpenThumbnail is Open for the scaled down version of an image attachment
+func (s *AttachmentService) OpenThumbnail(attachmentID, userID uint) (*model.Attachment, io.ReadCloser, error) {
+	attachment, err := s.getVisible(attachmentID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if attachment.ThumbnailKey == "" {
+		return nil, nil, ErrNoThumbnail
+	}
+
+	content, err := s.openBlob(attachment.ThumbnailKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return attachment, content, nil
+}
+
+// getVisible returns an attachment if the user may see it. Attachments that are not
+// sent yet are only visible to their uploader.
+func (s *AttachmentService) getVisible(attachmentID, userID uint) (*model.Attachment, error) {
+	attachment, err := s.attachmentRepo.GetByID(attachmentID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get attachment: %w", err)
+	}
+
+	if _, err := s.permissions.Require(userID, attachment.ChatID, PermReadMessages); err != nil {
+		return nil, fmt.Errorf("cant open attachment: %w", err)
+	}
+
+	if attachment.MessageID == nil {
+		if attachment.UploaderID != userID {
+			return nil, repoInterfaces.ErrAttachmentNotFound
+		}
+	} else if _, err := s.messageRepo.GetByID(*attachment.MessageID); err != nil {
+		// attachments go away together with their message
+		if errors.Is(err, repoInterfaces.ErrMessageNotFound) {
+			return nil, repoInterfaces.ErrAttachmentNotFound
+		}
+		return nil, fmt.Errorf("cant get attachment message: %w", err)
+	}
+
+	return attachment, nil
+}
+
+func (s *AttachmentService) openBlob(key string) (io.ReadCloser, error) {
+	content, err := s.blobs.Open(key)
+	if err != nil {
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			return nil, repoInterfaces.ErrAttachmentNotFound
+		}
+		return nil, fmt.Errorf("cant open attachment: %w", err)
+	}
+	return content, nil
+}
+
+func detectMimeType(head []byte) (string, error) {
+	if len(head) == 0 {
+		return "", ErrAttachmentTypeNotAllowed
+	}
+
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil || !allowedMimeTypes[mediaType] {
+		return "", ErrAttachmentTypeNotAllowed
+	}
+
+	return mediaType, nil
+}
+
+// sanitizeFileName keeps only the base name of what the client sent, so it is safe to
+// put into a Content-Disposition header later
+func sanitizeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+
+	for len(name) > maxFileNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+func newStorageKey() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("cant generate storage key: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
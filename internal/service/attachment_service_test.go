@@ -0,0 +1,60 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	// client supplied name -> what ends up in Content-Disposition
+	names := map[string]string{
+		"report.pdf":                   "report.pdf",
+		"/home/user/report.pdf":        "report.pdf",
+		`C:\Users\user\report.pdf`:     "report.pdf",
+		"../../etc/passwd":             "passwd",
+		"a\"b\r\nc\x00.txt":            "abc.txt",
+		"отчёт.pdf":                    "отчёт.pdf",
+		"":                             "file",
+		".":                            "file",
+		"/":                            "file",
+		"\x01\x02":                     "file",
+		strings.Repeat("a", 300):       strings.Repeat("a", maxFileNameLength),
+		strings.Repeat("ё", 200) + "x": strings.Repeat("ё", maxFileNameLength/2),
+	}
+
+	for name, want := range names {
+		if got := sanitizeFileName(name); got != want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestDetectMimeTypeAllowsKnownTypes(t *testing.T) {
+	heads := map[string]string{
+		"\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR": "image/png",
+		"\xff\xd8\xff\xe0\x00\x10JFIF":        "image/jpeg",
+		"GIF89a\x01\x00\x01\x00":              "image/gif",
+		"%PDF-1.7\n":                          "application/pdf",
+		"PK\x03\x04\x14\x00":                  "application/zip",
+		// the charset parameter is dropped
+		"hello world": "text/plain",
+		// not sniffed as an image, so it is served as text and never rendered
+		`<svg xmlns="http://www.w3.org/2000/svg"></svg>`: "text/plain",
+	}
+
+	for head, want := range heads {
+		got, err := detectMimeType([]byte(head))
+		if err != nil || got != want {
+			t.Errorf("detectMimeType(%q) = %q, %v, want %q", head, got, err, want)
+		}
+	}
+}
+
+func TestDetectMimeTypeRejectsUnsafeTypes(t *testing.T) {
+	for _, head := range []string{"", "<!DOCTYPE html><html></html>", "\x00\x01\x02\x03"} {
+		if _, err := detectMimeType([]byte(head)); !errors.Is(err, ErrAttachmentTypeNotAllowed) {
+			t.Errorf("detectMimeType(%q) error = %v, want ErrAttachmentTypeNotAllowed", head, err)
+		}
+	}
+}
@@ -2,18 +2,32 @@ package service
 
 import (
 	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
 	"simpleMessenger/internal/model"
 	"simpleMessenger/internal/repository/interfaces"
 )
 
+const (
+	MinPasswordLength = 8
+	// bcrypt ignores everything after the 72nd byte
+	MaxPasswordLength = 72
+)
+
 var (
-	ErrUserAlreadyExists = errors.New("user already exists")
+	ErrUserAlreadyExists  = errors.New("user already exists")
+	ErrInvalidPassword    = errors.New("invalid password")
+	ErrPasswordTooShort   = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	ErrPasswordTooLong    = fmt.Errorf("password must be at most %d bytes", MaxPasswordLength)
+	ErrPasswordNotDefined = errors.New("user has no password set")
 )
 
 type LoginResponse struct {
-	User      *model.User
-	Token     string
-	ExpiresIn int64
+	User             *model.User
+	Token            string
+	ExpiresIn        int64
+	RefreshToken     string
+	RefreshExpiresIn int64
 }
 
 type AuthService struct {
@@ -25,29 +39,98 @@ func NewAuthService(userRepo interfaces.UserRepo, tokenService TokenService) *Au
 	return &AuthService{userRepo: userRepo, tokenService: tokenService}
 }
 
-func (s *AuthService) Register(user *model.User) error {
+func (s *AuthService) Register(user *model.User, password string) error {
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+
 	existing, _ := s.userRepo.GetByLogin(user.Login)
 	if existing != nil {
 		return ErrUserAlreadyExists
 	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("cant hash password: %w", err)
+	}
+	user.PasswordHash = string(hash)
+
 	return s.userRepo.Create(user)
 }
 
-func (s *AuthService) Login(username string) (*LoginResponse, error) {
+func (s *AuthService) Login(username, password string) (*LoginResponse, error) {
 	user, err := s.userRepo.GetByLogin(username)
 	if err != nil {
+		if errors.Is(err, interfaces.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
 		return nil, err
 	}
 
-	token, err := s.tokenService.GenerateToken(user.ID)
+	if user.PasswordHash == "" {
+		return nil, ErrPasswordNotDefined
+	}
+
+	// CompareHashAndPassword compares the derived keys in constant time
+	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return nil, ErrInvalidPassword
+		}
+		return nil, fmt.Errorf("cant verify password: %w", err)
+	}
+
+	tokens, err := s.tokenService.IssueTokens(user.ID)
 	if err != nil {
 		return nil, err
 	}
 
+	return newLoginResponse(user, tokens), nil
+}
+
+func (s *AuthService) Refresh(refreshToken string) (*LoginResponse, error) {
+	tokens, err := s.tokenService.RefreshTokens(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByID(tokens.UserID)
+	if err != nil {
+		if errors.Is(err, interfaces.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return newLoginResponse(user, tokens), nil
+}
+
+func (s *AuthService) Logout(refreshToken string) error {
+	return s.tokenService.RevokeSession(refreshToken)
+}
+
+func (s *AuthService) LogoutAll(userID uint) error {
+	return s.tokenService.RevokeAllSessions(userID)
+}
+
+func newLoginResponse(user *model.User, tokens *TokenPair) *LoginResponse {
 	return &LoginResponse{
-		User:      user,
-		Token:     token,
-		ExpiresIn: AccessTokenExpireDuration,
-	}, nil
+		User:             user,
+		Token:            tokens.AccessToken,
+		ExpiresIn:        tokens.ExpiresIn,
+		RefreshToken:     tokens.RefreshToken,
+		RefreshExpiresIn: tokens.RefreshExpiresIn,
+	}
+}
+
+func validatePassword(password string) error {
+	if len([]rune(password)) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+
+	if len(password) > MaxPasswordLength {
+		return ErrPasswordTooLong
+	}
 
+	return nil
 }
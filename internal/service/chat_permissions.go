@@ -0,0 +1,83 @@
+package service
+
+import (
+	"errors"
+	"simpleMessenger/internal/model"
+)
+
+var (
+	ErrPermissionDenied = errors.New("not enough permissions in chat")
+	ErrInvalidChatRole  = errors.New("invalid chat role")
+)
+
+type ChatPermission int
+
+const (
+	PermReadMessages ChatPermission = iota
+	PermSendMessage
+	PermAddMembers
+	PermRemoveMembers
+	PermDeleteAnyMessage
+	PermManageRoles
+	PermDeleteChat
+)
+
+var rolePermissions = map[model.ChatRole][]ChatPermission{
+	model.ChatRoleOwner: {
+		PermReadMessages, PermSendMessage, PermAddMembers, PermRemoveMembers,
+		PermDeleteAnyMessage, PermManageRoles, PermDeleteChat,
+	},
+	model.ChatRoleAdmin: {
+		PermReadMessages, PermSendMessage, PermAddMembers, PermRemoveMembers, PermDeleteAnyMessage,
+	},
+	model.ChatRoleMember: {
+		PermReadMessages, PermSendMessage,
+	},
+}
+
+var roleRanks = map[model.ChatRole]int{
+	model.ChatRoleMember: 1,
+	model.ChatRoleAdmin:  2,
+	model.ChatRoleOwner:  3,
+}
+
+func roleHasPermission(role model.ChatRole, perm ChatPermission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// outranks reports whether a participant with role a may manage a participant with role b
+func outranks(a, b model.ChatRole) bool {
+	return roleRanks[a] > roleRanks[b]
+}
+
+func isValidChatRole(role model.ChatRole) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+type permissionChecker struct {
+	members *MembershipCache
+}
+
+func newPermissionChecker(members *MembershipCache) *permissionChecker {
+	return &permissionChecker{members: members}
+}
+
+// Require returns the caller's role in the chat if it grants perm
+func (c *permissionChecker) Require(userID, chatID uint, perm ChatPermission) (model.ChatRole, error) {
+	role, err := c.members.Role(userID, chatID)
+	if err != nil {
+		return "", err
+	}
+
+	if !roleHasPermission(role, perm) {
+		return "", ErrPermissionDenied
+	}
+
+	return role, nil
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"simpleMessenger/internal/model"
+	"slices"
+	"testing"
+)
+
+var allPermissions = []ChatPermission{
+	PermReadMessages, PermSendMessage, PermAddMembers, PermRemoveMembers,
+	PermDeleteAnyMessage, PermManageRoles, PermDeleteChat,
+}
+
+// every role is checked against every permission, so a new permission can't be granted by accident
+func TestRoleHasPermission(t *testing.T) {
+	granted := map[model.ChatRole][]ChatPermission{
+		model.ChatRoleMember: {PermReadMessages, PermSendMessage},
+		model.ChatRoleAdmin:  {PermReadMessages, PermSendMessage, PermAddMembers, PermRemoveMembers, PermDeleteAnyMessage},
+		model.ChatRoleOwner:  allPermissions,
+		"guest":              nil,
+	}
+
+	for role, perms := range granted {
+		for _, perm := range allPermissions {
+			want := slices.Contains(perms, perm)
+			if got := roleHasPermission(role, perm); got != want {
+				t.Errorf("roleHasPermission(%q, %d) = %v, want %v", role, perm, got, want)
+			}
+		}
+	}
+}
+
+func TestOutranks(t *testing.T) {
+	// from the highest rank down, an unknown role ranks below everyone
+	ranked := []model.ChatRole{model.ChatRoleOwner, model.ChatRoleAdmin, model.ChatRoleMember, "guest"}
+
+	for i, a := range ranked {
+		for j, b := range ranked {
+			if got, want := outranks(a, b), i < j; got != want {
+				t.Errorf("outranks(%q, %q) = %v, want %v", a, b, got, want)
+			}
+		}
+	}
+}
@@ -1,22 +1,39 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"simpleMessenger/internal/model"
 	repoInterfaces "simpleMessenger/internal/repository/interfaces"
+	"strings"
 	"time"
 )
 
+const (
+	MaxGroupChatMembers = 200
+	MaxChatTitleLength  = 128
+)
+
+var (
+	ErrEmptyChatTitle   = errors.New("chat title cannot be empty")
+	ErrChatTitleTooLong = fmt.Errorf("chat title must be at most %d characters", MaxChatTitleLength)
+	ErrTooManyMembers   = fmt.Errorf("group chat cannot have more than %d members", MaxGroupChatMembers)
+	ErrNotGroupChat     = errors.New("operation is allowed only in group chats")
+	ErrUserNotInChat    = errors.New("user is not in chat")
+)
+
 type ChatService struct {
 	chatRepo             repoInterfaces.ChatRepo
 	chatParticipantsRepo repoInterfaces.ChatParticipantsRepo
 	messageRepo          repoInterfaces.MessageRepo
 	userRepo             repoInterfaces.UserRepo
+	members              *MembershipCache
+	permissions          *permissionChecker
 }
 
-func NewChatService(chatRepo repoInterfaces.ChatRepo, chatParticipantsRepo repoInterfaces.ChatParticipantsRepo, messageRepo repoInterfaces.MessageRepo, userRepo repoInterfaces.UserRepo) *ChatService {
-	return &ChatService{chatRepo, chatParticipantsRepo, messageRepo, userRepo}
+func NewChatService(chatRepo repoInterfaces.ChatRepo, chatParticipantsRepo repoInterfaces.ChatParticipantsRepo, messageRepo repoInterfaces.MessageRepo, userRepo repoInterfaces.UserRepo, members *MembershipCache) *ChatService {
+	return &ChatService{chatRepo, chatParticipantsRepo, messageRepo, userRepo, members, newPermissionChecker(members)}
 }
 
 func (s *ChatService) CreateChat(firstUserID, secondUserID uint) error {
@@ -51,14 +68,17 @@ func (s *ChatService) CreateChat(firstUserID, secondUserID uint) error {
 		return fmt.Errorf("cant create chat: %w", err)
 	}
 
+	// both sides of a direct chat own it, so either of them can delete it
 	chatParticipantsFirst := &model.ChatParticipants{
 		ChatID: chat.ID,
 		UserID: firstUserID,
+		Role:   model.ChatRoleOwner,
 	}
 
 	chatParticipantsSecond := &model.ChatParticipants{
 		ChatID: chat.ID,
 		UserID: secondUserID,
+		Role:   model.ChatRoleOwner,
 	}
 
 	err = s.chatParticipantsRepo.Create(chatParticipantsFirst)
@@ -74,9 +94,254 @@ func (s *ChatService) CreateChat(firstUserID, secondUserID uint) error {
 		return fmt.Errorf("cant create chat participants: %w", err)
 	}
 
+	s.members.Invalidate(chat.ID)
+	return nil
+}
+
+func (s *ChatService) CreateGroupChat(ownerID uint, title string, memberIDs []uint) (*model.Chat, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return nil, ErrEmptyChatTitle
+	}
+
+	if len([]rune(title)) > MaxChatTitleLength {
+		return nil, ErrChatTitleTooLong
+	}
+
+	members := uniqueIDs(append([]uint{ownerID}, memberIDs...))
+	if len(members) > MaxGroupChatMembers {
+		return nil, ErrTooManyMembers
+	}
+
+	for _, memberID := range members {
+		if _, err := s.userRepo.GetByID(memberID); err != nil {
+			return nil, fmt.Errorf("cant get member %d by id: %w", memberID, err)
+		}
+	}
+
+	chat := &model.Chat{
+		LastMessageAt: time.Now(),
+		Name:          title,
+		IsGroup:       true,
+	}
+
+	err := s.chatRepo.Create(chat)
+	if err != nil {
+		return nil, fmt.Errorf("cant create group chat: %w", err)
+	}
+
+	participants := make([]*model.ChatParticipants, 0, len(members))
+	for _, memberID := range members {
+		role := model.ChatRoleMember
+		if memberID == ownerID {
+			role = model.ChatRoleOwner
+		}
+		participants = append(participants, &model.ChatParticipants{
+			ChatID: chat.ID,
+			UserID: memberID,
+			Role:   role,
+		})
+	}
+
+	err = s.chatParticipantsRepo.CreateBatch(participants)
+	if err != nil {
+		_ = s.chatRepo.Delete(chat.ID)
+		return nil, fmt.Errorf("cant create chat participants: %w", err)
+	}
+
+	s.members.Invalidate(chat.ID)
+	return chat, nil
+}
+
+func (s *ChatService) AddMembers(chatID, actorID uint, memberIDs []uint) error {
+	chat, err := s.getGroupChat(chatID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.permissions.Require(actorID, chat.ID, PermAddMembers); err != nil {
+		return err
+	}
+
+	count, err := s.chatParticipantsRepo.CountParticipants(chat.ID)
+	if err != nil {
+		return fmt.Errorf("cant count chat participants: %w", err)
+	}
+
+	newParticipants := make([]*model.ChatParticipants, 0, len(memberIDs))
+	for _, memberID := range uniqueIDs(memberIDs) {
+		isUserInChat, err := s.chatParticipantsRepo.IsUserInChat(memberID, chat.ID)
+		if err != nil {
+			return fmt.Errorf("cant check if user is in chat: %w", err)
+		}
+
+		if isUserInChat {
+			continue
+		}
+
+		if _, err := s.userRepo.GetByID(memberID); err != nil {
+			return fmt.Errorf("cant get member %d by id: %w", memberID, err)
+		}
+
+		newParticipants = append(newParticipants, &model.ChatParticipants{
+			ChatID: chat.ID,
+			UserID: memberID,
+			Role:   model.ChatRoleMember,
+		})
+	}
+
+	if int(count)+len(newParticipants) > MaxGroupChatMembers {
+		return ErrTooManyMembers
+	}
+
+	err = s.chatParticipantsRepo.CreateBatch(newParticipants)
+	if err != nil {
+		return fmt.Errorf("cant add chat participants: %w", err)
+	}
+
+	s.members.Invalidate(chat.ID)
+	return nil
+}
+
+func (s *ChatService) RemoveMember(chatID, actorID, memberID uint) error {
+	if actorID == memberID {
+		return s.LeaveChat(chatID, actorID)
+	}
+
+	chat, err := s.getGroupChat(chatID)
+	if err != nil {
+		return err
+	}
+
+	actorRole, err := s.permissions.Require(actorID, chat.ID, PermRemoveMembers)
+	if err != nil {
+		return err
+	}
+
+	participant, err := s.chatParticipantsRepo.GetParticipant(memberID, chat.ID)
+	if err != nil {
+		if errors.Is(err, repoInterfaces.ErrChatParticipantsNotFound) {
+			return ErrUserNotInChat
+		}
+		return fmt.Errorf("cant get chat participant: %w", err)
+	}
+
+	if !outranks(actorRole, participant.Role) {
+		return ErrPermissionDenied
+	}
+
+	err = s.chatParticipantsRepo.Delete(participant.ID)
+	if err != nil {
+		return fmt.Errorf("cant remove chat participant: %w", err)
+	}
+
+	s.members.Invalidate(chat.ID)
+	return nil
+}
+
+func (s *ChatService) LeaveChat(chatID, userID uint) error {
+	chat, err := s.getGroupChat(chatID)
+	if err != nil {
+		return err
+	}
+
+	// an owner hands the chat over while leaving, it is never without one
+	remaining, err := s.chatParticipantsRepo.Leave(userID, chat.ID)
+	if err != nil {
+		if errors.Is(err, repoInterfaces.ErrChatParticipantsNotFound) {
+			return ErrUserNotInChat
+		}
+		return fmt.Errorf("cant leave chat: %w", err)
+	}
+	s.members.Invalidate(chat.ID)
+
+	// nobody is left to read the history, so the chat goes away with the last member
+	if remaining == 0 {
+		return s.deleteChat(chat.ID)
+	}
+
 	return nil
 }
 
+func (s *ChatService) SetMemberRole(chatID, actorID, memberID uint, role model.ChatRole) error {
+	if !isValidChatRole(role) {
+		return ErrInvalidChatRole
+	}
+
+	chat, err := s.getGroupChat(chatID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.permissions.Require(actorID, chat.ID, PermManageRoles); err != nil {
+		return err
+	}
+
+	if actorID == memberID {
+		return ErrPermissionDenied
+	}
+
+	// a chat has exactly one owner, so handing it over demotes the current one in the same
+	// transaction
+	if role == model.ChatRoleOwner {
+		err = s.chatParticipantsRepo.TransferOwnership(chat.ID, actorID, memberID)
+		if err != nil {
+			switch {
+			case errors.Is(err, repoInterfaces.ErrChatParticipantsNotFound):
+				return ErrUserNotInChat
+			case errors.Is(err, repoInterfaces.ErrNotChatOwner):
+				return ErrPermissionDenied
+			}
+			return fmt.Errorf("cant transfer chat ownership: %w", err)
+		}
+		s.members.Invalidate(chat.ID)
+		return nil
+	}
+
+	participant, err := s.chatParticipantsRepo.GetParticipant(memberID, chat.ID)
+	if err != nil {
+		if errors.Is(err, repoInterfaces.ErrChatParticipantsNotFound) {
+			return ErrUserNotInChat
+		}
+		return fmt.Errorf("cant get chat participant: %w", err)
+	}
+
+	participant.Role = role
+	err = s.chatParticipantsRepo.Update(participant)
+	if err != nil {
+		return fmt.Errorf("cant update participant role: %w", err)
+	}
+	s.members.Invalidate(chat.ID)
+
+	return nil
+}
+
+func (s *ChatService) getGroupChat(chatID uint) (*model.Chat, error) {
+	chat, err := s.chatRepo.GetByID(chatID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get chat: %w", err)
+	}
+
+	if !chat.IsGroup {
+		return nil, ErrNotGroupChat
+	}
+
+	return chat, nil
+}
+
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]struct{}, len(ids))
+	result := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok || id == 0 {
+			continue
+		}
+		seen[id] = struct{}{}
+		result = append(result, id)
+	}
+	return result
+}
+
 func formatChatName(login1, login2 string) string {
 	if login1 < login2 {
 		return login1 + " • " + login2
@@ -92,8 +357,43 @@ func (s *ChatService) GetChats(userID uint, limit int) ([]*model.Chat, error) {
 	return chats, nil
 }
 
+// ChatPage is one page of the chat list, newest activity first. NextCursor continues the list.
+type ChatPage struct {
+	Chats      []*model.ChatSummary `json:"chats"`
+	NextCursor string               `json:"nextCursor,omitempty"`
+	HasMore    bool                 `json:"hasMore"`
+}
+
+func (s *ChatService) GetChatSummaries(userID uint, limit int, cursor string) (*ChatPage, error) {
+	query := repoInterfaces.ChatPageQuery{Limit: limit + 1}
+
+	if cursor != "" {
+		before, err := DecodeChatCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		query.Before = before
+	}
+
+	summaries, err := s.chatRepo.GetChatSummaries(userID, query)
+	if err != nil {
+		return nil, fmt.Errorf("cant get chat summaries by userID: %w", err)
+	}
+
+	page := &ChatPage{Chats: summaries, HasMore: len(summaries) > limit}
+	if page.HasMore {
+		page.Chats = summaries[:limit]
+	}
+
+	if len(page.Chats) > 0 {
+		page.NextCursor = EncodeChatCursor(page.Chats[len(page.Chats)-1].Chat)
+	}
+
+	return page, nil
+}
+
 func (s *ChatService) GetUsersInChat(chatId uint) ([]uint, error) {
-	userIDs, err := s.chatParticipantsRepo.GetChatParticipantsByChatID(chatId)
+	userIDs, err := s.members.UserIDs(chatId)
 
 	if err != nil {
 		log.Printf("cant get users by chat id: %v", chatId)
@@ -103,17 +403,51 @@ func (s *ChatService) GetUsersInChat(chatId uint) ([]uint, error) {
 	return userIDs, nil
 }
 
-func (s *ChatService) DeleteChat(chatID, userID uint) error {
-	isUserInChat, err := s.chatParticipantsRepo.IsUserInChat(userID, chatID)
+// GetContacts returns the users that share at least one chat with userID
+func (s *ChatService) GetContacts(userID uint) ([]uint, error) {
+	userIDs, err := s.chatParticipantsRepo.GetCoParticipants(userID)
 	if err != nil {
-		return fmt.Errorf("cant check if user is in chat: %w", err)
+		return nil, fmt.Errorf("cant get contacts of user: %w", err)
 	}
 
-	if !isUserInChat {
-		return fmt.Errorf("cant delete other user's chat")
+	return userIDs, nil
+}
+
+// SharesChat reports whether userID can see otherUserID, which takes a chat they are both in
+func (s *ChatService) SharesChat(userID, otherUserID uint) (bool, error) {
+	if userID == otherUserID {
+		return true, nil
+	}
+
+	shared, err := s.chatParticipantsRepo.SharesChat(userID, otherUserID)
+	if err != nil {
+		return false, fmt.Errorf("cant check shared chat: %w", err)
+	}
+
+	return shared, nil
+}
+
+// DeleteChat removes the chat with its history and returns the users that were in it
+func (s *ChatService) DeleteChat(chatID, userID uint) ([]uint, error) {
+	if _, err := s.permissions.Require(userID, chatID, PermDeleteChat); err != nil {
+		return nil, fmt.Errorf("cant delete chat: %w", err)
 	}
 
-	err = s.messageRepo.DeleteAllMessagesInChat(chatID)
+	participants, err := s.members.UserIDs(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.deleteChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	return participants, nil
+}
+
+func (s *ChatService) deleteChat(chatID uint) error {
+	err := s.messageRepo.DeleteAllMessagesInChat(chatID)
 	if err != nil {
 		return fmt.Errorf("cant delete all messages in chat: %w", err)
 	}
@@ -122,6 +456,7 @@ func (s *ChatService) DeleteChat(chatID, userID uint) error {
 	if err != nil {
 		return fmt.Errorf("cant delete chat from chat participants: %w", err)
 	}
+	s.members.Invalidate(chatID)
 
 	err = s.chatRepo.Delete(chatID)
 	if err != nil {
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"simpleMessenger/internal/model"
+	repoInterfaces "simpleMessenger/internal/repository/interfaces"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// encodeCursor turns a (timestamp, id) position into an opaque string for clients
+func encodeCursor(t time.Time, id uint) string {
+	raw := fmt.Sprintf("%d:%d", t.UnixMicro(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+
+	micros, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+
+	return time.UnixMicro(micros), uint(id), nil
+}
+
+func EncodeMessageCursor(message *model.Message) string {
+	return encodeCursor(message.CreatedAt, message.ID)
+}
+
+func DecodeMessageCursor(cursor string) (*repoInterfaces.MessageCursor, error) {
+	createdAt, id, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &repoInterfaces.MessageCursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+func messageCursor(message *model.Message) *repoInterfaces.MessageCursor {
+	return &repoInterfaces.MessageCursor{CreatedAt: message.CreatedAt, ID: message.ID}
+}
+
+func EncodeChatCursor(chat *model.Chat) string {
+	return encodeCursor(chat.LastMessageAt, chat.ID)
+}
+
+func DecodeChatCursor(cursor string) (*repoInterfaces.ChatCursor, error) {
+	lastMessageAt, id, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &repoInterfaces.ChatCursor{LastMessageAt: lastMessageAt, ID: id}, nil
+}
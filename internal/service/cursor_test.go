@@ -0,0 +1,72 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		at   time.Time
+		id   uint
+	}{
+		{"zero", time.UnixMicro(0), 0},
+		{"now", time.Date(2026, 10, 18, 12, 30, 0, 123456000, time.UTC), 42},
+		{"before epoch", time.UnixMicro(-1_000_000), 7},
+		{"large id", time.UnixMicro(1), ^uint(0)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			at, id, err := decodeCursor(encodeCursor(test.at, test.id))
+			if err != nil {
+				t.Fatalf("decodeCursor() error = %v", err)
+			}
+			if !at.Equal(test.at) || id != test.id {
+				t.Errorf("decodeCursor() = %v, %d, want %v, %d", at, id, test.at, test.id)
+			}
+		})
+	}
+}
+
+func TestCursorTruncatesToMicroseconds(t *testing.T) {
+	at := time.Unix(100, 1999)
+
+	decoded, _, err := decodeCursor(encodeCursor(at, 1))
+	if err != nil {
+		t.Fatalf("decodeCursor() error = %v", err)
+	}
+	if want := time.Unix(100, 1000); !decoded.Equal(want) {
+		t.Errorf("decodeCursor() time = %v, want %v", decoded, want)
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"empty", ""},
+		{"not base64", "!!!"},
+		{"no separator", encode("123")},
+		{"time not a number", encode("abc:1")},
+		{"id not a number", encode("1:abc")},
+		{"negative id", encode("1:-1")},
+		{"extra part", encode("1:2:3")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := decodeCursor(test.cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeCursor(%q) error = %v, want ErrInvalidCursor", test.cursor, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxShortcodeLength = 32
+
+	zeroWidthJoiner = '\u200d'
+	keycap          = '\u20e3'
+	variationEmoji  = '\ufe0f'
+)
+
+// emojiBases are the code points an emoji can start with, or follow a zero width joiner with
+var emojiBases = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00a9, Hi: 0x00ae, Stride: 5},
+		{Lo: 0x203c, Hi: 0x2049, Stride: 13},
+		{Lo: 0x2122, Hi: 0x2139, Stride: 23},
+		{Lo: 0x2194, Hi: 0x21aa, Stride: 1},
+		{Lo: 0x231a, Hi: 0x23ff, Stride: 1},
+		{Lo: 0x24c2, Hi: 0x24c2, Stride: 1},
+		{Lo: 0x25aa, Hi: 0x25fe, Stride: 1},
+		{Lo: 0x2600, Hi: 0x27bf, Stride: 1},
+		{Lo: 0x2934, Hi: 0x2935, Stride: 1},
+		{Lo: 0x2b05, Hi: 0x2b55, Stride: 1},
+		{Lo: 0x3030, Hi: 0x303d, Stride: 13},
+		{Lo: 0x3297, Hi: 0x3299, Stride: 2},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f000, Hi: 0x1f1e5, Stride: 1},
+		{Lo: 0x1f200, Hi: 0x1f3fa, Stride: 1},
+		{Lo: 0x1f400, Hi: 0x1faff, Stride: 1},
+	},
+	LatinOffset: 1,
+}
+
+// emojiModifiers only change the emoji before them: variation selectors, skin tones and
+// the tags of subdivision flags
+var emojiModifiers = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0xfe0e, Hi: 0xfe0f, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f3fb, Hi: 0x1f3ff, Stride: 1},
+		{Lo: 0xe0020, Hi: 0xe007f, Stride: 1},
+	},
+}
+
+// isValidEmoji accepts a single emoji, joined sequences like families included, or a
+// short name like :thumbsup:. Anything else would let reactions carry arbitrary text.
+func isValidEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > MaxEmojiLength || !utf8.ValidString(emoji) {
+		return false
+	}
+
+	if emoji[0] == ':' {
+		return isShortcode(emoji)
+	}
+	return isFlag(emoji) || isKeycap(emoji) || isEmojiSequence(emoji)
+}
+
+func isShortcode(emoji string) bool {
+	if len(emoji) < 3 || len(emoji) > maxShortcodeLength+2 || emoji[len(emoji)-1] != ':' {
+		return false
+	}
+
+	for _, r := range emoji[1 : len(emoji)-1] {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '+' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// isEmojiSequence allows one base emoji with its modifiers, further bases only after a joiner
+func isEmojiSequence(emoji string) bool {
+	var prev rune
+	for _, r := range emoji {
+		switch {
+		case r == zeroWidthJoiner:
+			if prev == 0 || prev == zeroWidthJoiner {
+				return false
+			}
+		case unicode.Is(emojiModifiers, r):
+			if prev == 0 || prev == zeroWidthJoiner {
+				return false
+			}
+		case unicode.Is(emojiBases, r):
+			if prev != 0 && prev != zeroWidthJoiner {
+				return false
+			}
+		default:
+			return false
+		}
+		prev = r
+	}
+	return prev != zeroWidthJoiner
+}
+
+// isFlag matches a country flag, a pair of regional indicators
+func isFlag(emoji string) bool {
+	runes := []rune(emoji)
+	return len(runes) == 2 && isRegionalIndicator(runes[0]) && isRegionalIndicator(runes[1])
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// isKeycap matches a digit, # or * in a keycap, with or without the variation selector
+func isKeycap(emoji string) bool {
+	runes := []rune(emoji)
+	if len(runes) == 3 && runes[1] == variationEmoji {
+		runes = []rune{runes[0], runes[2]}
+	}
+	if len(runes) != 2 || runes[1] != keycap {
+		return false
+	}
+	return runes[0] >= '0' && runes[0] <= '9' || runes[0] == '#' || runes[0] == '*'
+}
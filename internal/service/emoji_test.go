@@ -0,0 +1,45 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsValidEmoji(t *testing.T) {
+	tests := []struct {
+		name  string
+		emoji string
+		want  bool
+	}{
+		{"single emoji", "\U0001F44D", true},
+		{"with skin tone", "\U0001F44D\U0001F3FD", true},
+		{"with variation selector", "\u2764\ufe0f", true},
+		{"joined family", "\U0001F468\u200d\U0001F469\u200d\U0001F467", true},
+		{"flag", "\U0001F1FA\U0001F1E6", true},
+		{"keycap", "1\ufe0f\u20e3", true},
+		{"shortcode", ":thumbsup:", true},
+		{"shortcode with digits and signs", ":+1_x-2:", true},
+		{"empty", "", false},
+		{"plain text", "lol", false},
+		{"emoji with text", "\U0001F44Dnice", false},
+		{"two emojis", "\U0001F44D\U0001F44D", false},
+		{"dangling joiner", "\U0001F468\u200d", false},
+		{"lone modifier", "\U0001F3FD", false},
+		{"single regional indicator", "\U0001F1FA", false},
+		{"digit without keycap", "1", false},
+		{"uppercase shortcode", ":ThumbsUp:", false},
+		{"shortcode with space", ":thumbs up:", false},
+		{"unclosed shortcode", ":thumbsup", false},
+		{"empty shortcode", "::", false},
+		{"long shortcode", ":" + strings.Repeat("a", maxShortcodeLength+1) + ":", false},
+		{"invalid utf8", "\xff", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isValidEmoji(test.emoji); got != test.want {
+				t.Errorf("isValidEmoji(%q) = %v, want %v", test.emoji, got, test.want)
+			}
+		})
+	}
+}
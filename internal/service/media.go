@@ -0,0 +1,243 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	"golang.org/x/image/draw"
+
+	_ "image/gif"
+
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// thumbnailSize is the bounding box a thumbnail is scaled into
+	thumbnailSize    = 320
+	thumbnailQuality = 80
+	// maxImagePixels keeps a small file that claims huge dimensions from being decoded,
+	// a decoded image takes up to four bytes per pixel
+	maxImagePixels = 16_000_000
+	// maxConcurrentThumbnails bounds how many images are decoded at once
+	maxConcurrentThumbnails = 2
+	// exifSearchLength is how much of a JPEG is searched for the EXIF block, which has to
+	// come before the image data and can't be larger than 64KiB
+	exifSearchLength = 128 << 10
+)
+
+// thumbnailSlots is taken for every decode, uploads beyond it wait for their turn
+var thumbnailSlots = make(chan struct{}, maxConcurrentThumbnails)
+
+type imageInfo struct {
+	// width and height are of the stored pixels, which the orientation may turn
+	width  int
+	height int
+	// orientation is the EXIF orientation, 1 when the image is shown as stored
+	orientation int
+}
+
+// displaySize is the size of the image as viewers see it
+func (i *imageInfo) displaySize() (int, int) {
+	if i.orientation >= 5 {
+		return i.height, i.width
+	}
+	return i.width, i.height
+}
+
+type thumbnail struct {
+	data     []byte
+	mimeType string
+	width    int
+	height   int
+}
+
+func isImageMimeType(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "image/")
+}
+
+// readImageInfo reads the dimensions and orientation from the image header without
+// decoding pixels
+func readImageInfo(r io.Reader) (*imageInfo, error) {
+	buffered := bufio.NewReaderSize(r, exifSearchLength)
+	// a short file is fine, Peek returns what there is
+	head, _ := buffered.Peek(exifSearchLength)
+
+	config, format, err := image.DecodeConfig(buffered)
+	if err != nil {
+		return nil, fmt.Errorf("cant read image header: %w", err)
+	}
+
+	info := &imageInfo{width: config.Width, height: config.Height, orientation: 1}
+	if format == "jpeg" {
+		info.orientation = exifOrientation(head)
+	}
+	return info, nil
+}
+
+// makeThumbnail scales an image down to fit thumbnailSize. Images that may be
+// transparent stay PNG, everything else becomes JPEG.
+func makeThumbnail(r io.Reader, info *imageInfo, mimeType string) (*thumbnail, error) {
+	if info.width <= 0 || info.height <= 0 || info.width*info.height > maxImagePixels {
+		return nil, fmt.Errorf("image of %dx%d is not thumbnailed", info.width, info.height)
+	}
+
+	thumbnailSlots <- struct{}{}
+	defer func() { <-thumbnailSlots }()
+
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("cant decode image: %w", err)
+	}
+
+	width, height := fitInto(info.width, info.height, thumbnailSize)
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), src, src.Bounds(), draw.Src, nil)
+	// the thumbnail carries no EXIF, so it is turned the way viewers expect instead
+	dst := orient(scaled, info.orientation)
+
+	buf := &bytes.Buffer{}
+	result := &thumbnail{width: dst.Bounds().Dx(), height: dst.Bounds().Dy()}
+
+	if mimeType == "image/png" || mimeType == "image/gif" {
+		result.mimeType = "image/png"
+		err = png.Encode(buf, dst)
+	} else {
+		result.mimeType = "image/jpeg"
+		err = jpeg.Encode(buf, dst, &jpeg.Options{Quality: thumbnailQuality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cant encode thumbnail: %w", err)
+	}
+
+	result.data = buf.Bytes()
+	return result, nil
+}
+
+// fitInto scales width and height down to fit a square box, keeping the aspect ratio.
+// Images that already fit are left as they are.
+func fitInto(width, height, box int) (int, int) {
+	if width <= box && height <= box {
+		return width, height
+	}
+
+	if width >= height {
+		return box, max(1, height*box/width)
+	}
+	return max(1, width*box/height), box
+}
+
+// orient turns an image as its EXIF orientation says it should be shown
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	bounds := image.Rect(0, 0, width, height)
+	if orientation >= 5 {
+		bounds = image.Rect(0, 0, height, width)
+	}
+	dst := image.NewRGBA(bounds)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = width-1-x, y
+			case 3:
+				dx, dy = width-1-x, height-1-y
+			case 4:
+				dx, dy = x, height-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = height-1-y, x
+			case 7:
+				dx, dy = height-1-y, width-1-x
+			case 8:
+				dx, dy = y, width-1-x
+			}
+			dst.SetRGBA(dx, dy, src.RGBAAt(x, y))
+		}
+	}
+	return dst
+}
+
+// exifOrientation finds the orientation tag in the EXIF block of a JPEG. Anything it
+// can't make sense of counts as the normal orientation.
+func exifOrientation(jpeg []byte) int {
+	if len(jpeg) < 4 || jpeg[0] != 0xff || jpeg[1] != 0xd8 {
+		return 1
+	}
+
+	for pos := 2; pos+4 <= len(jpeg); {
+		if jpeg[pos] != 0xff {
+			return 1
+		}
+		marker := jpeg[pos+1]
+		// the image data starts, EXIF comes before it
+		if marker == 0xda || marker == 0xd9 {
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(jpeg[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(jpeg) {
+			return 1
+		}
+
+		segment := jpeg[pos+4 : end]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		pos = end
+	}
+	return 1
+}
+
+// tiffOrientation reads tag 0x0112 from the first IFD of a TIFF header
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		// a SHORT value sits in the first two bytes of the value field
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// jpegWithOrientation builds the start of a JPEG whose EXIF block holds one orientation tag
+func jpegWithOrientation(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	jpeg := []byte{0xff, 0xd8, 0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(jpeg[4:], uint16(len(segment)+2))
+	jpeg = append(jpeg, segment...)
+	return append(jpeg, 0xff, 0xda)
+}
+
+func TestExifOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"big endian", jpegWithOrientation(binary.BigEndian, 6), 6},
+		{"little endian", jpegWithOrientation(binary.LittleEndian, 8), 8},
+		{"out of range", jpegWithOrientation(binary.BigEndian, 9), 1},
+		{"no exif", []byte{0xff, 0xd8, 0xff, 0xda}, 1},
+		{"not a jpeg", []byte("GIF89a"), 1},
+		{"truncated", jpegWithOrientation(binary.BigEndian, 6)[:20], 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := exifOrientation(test.data); got != test.want {
+				t.Errorf("exifOrientation() = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOrient(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+
+	// a 2x1 image with its red pixel on the left
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.SetRGBA(0, 0, red)
+
+	tests := []struct {
+		orientation   int
+		width, height int
+		redX, redY    int
+	}{
+		{1, 2, 1, 0, 0},
+		{2, 2, 1, 1, 0},
+		{3, 2, 1, 1, 0},
+		{4, 2, 1, 0, 0},
+		{5, 1, 2, 0, 0},
+		{6, 1, 2, 0, 0},
+		{7, 1, 2, 0, 1},
+		{8, 1, 2, 0, 1},
+	}
+
+	for _, test := range tests {
+		dst := orient(src, test.orientation)
+
+		if dst.Bounds().Dx() != test.width || dst.Bounds().Dy() != test.height {
+			t.Errorf("orient(%d) size = %v, want %dx%d", test.orientation, dst.Bounds().Size(), test.width, test.height)
+			continue
+		}
+		if dst.RGBAAt(test.redX, test.redY) != red {
+			t.Errorf("orient(%d) red pixel is not at %d,%d", test.orientation, test.redX, test.redY)
+		}
+	}
+}
+
+func TestFitIntoLeavesSmallImages(t *testing.T) {
+	for _, size := range [][2]int{{1, 1}, {100, 50}, {320, 320}, {50, 320}} {
+		if width, height := fitInto(size[0], size[1], 320); width != size[0] || height != size[1] {
+			t.Errorf("fitInto(%d, %d) = %dx%d, want it unchanged", size[0], size[1], width, height)
+		}
+	}
+}
+
+func TestFitIntoScalesLongerSideToBox(t *testing.T) {
+	if width, height := fitInto(1280, 640, 320); width != 320 || height != 160 {
+		t.Errorf("landscape fitInto() = %dx%d, want 320x160", width, height)
+	}
+	if width, height := fitInto(640, 1280, 320); width != 160 || height != 320 {
+		t.Errorf("portrait fitInto() = %dx%d, want 160x320", width, height)
+	}
+	if width, height := fitInto(1000, 1000, 320); width != 320 || height != 320 {
+		t.Errorf("square fitInto() = %dx%d, want 320x320", width, height)
+	}
+
+	// a sliver of an image still gets a visible thumbnail
+	if width, height := fitInto(10000, 1, 320); width != 320 || height != 1 {
+		t.Errorf("thin fitInto() = %dx%d, want 320x1", width, height)
+	}
+}
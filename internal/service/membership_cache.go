@@ -0,0 +1,185 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"simpleMessenger/internal/broker"
+	"simpleMessenger/internal/model"
+	repoInterfaces "simpleMessenger/internal/repository/interfaces"
+	"sync"
+	"time"
+)
+
+const (
+	// membershipCacheTTL bounds how long stale membership may be acted on when an
+	// invalidation from another instance got lost, normally they arrive right away
+	membershipCacheTTL = 30 * time.Second
+	maxCachedChats     = 10000
+
+	topicMembership = "membership"
+)
+
+type chatMembers struct {
+	roles     map[uint]model.ChatRole
+	userIDs   []uint
+	expiresAt time.Time
+}
+
+// MembershipCache keeps who is in a chat and with which role, so permission checks and
+// fan-out of a busy chat don't go to the database for every message. Services that
+// change participants must call Invalidate afterwards, which reaches the caches of all
+// instances through the broker.
+type MembershipCache struct {
+	chatParticipantsRepo repoInterfaces.ChatParticipantsRepo
+	broker               broker.Broker
+
+	mu    sync.RWMutex
+	chats map[uint]*chatMembers
+	// generation changes on every invalidation, a load that raced with one is not stored
+	generation uint64
+}
+
+func NewMembershipCache(chatParticipantsRepo repoInterfaces.ChatParticipantsRepo, broker broker.Broker) *MembershipCache {
+	cache := &MembershipCache{
+		chatParticipantsRepo: chatParticipantsRepo,
+		broker:               broker,
+		chats:                make(map[uint]*chatMembers),
+	}
+	broker.Subscribe(cache.handle)
+	return cache
+}
+
+// Role returns the role of userID in the chat, or ErrUserNotInChat
+func (c *MembershipCache) Role(userID, chatID uint) (model.ChatRole, error) {
+	members, err := c.get(chatID)
+	if err != nil {
+		return "", err
+	}
+
+	role, ok := members.roles[userID]
+	if !ok {
+		return "", ErrUserNotInChat
+	}
+	return role, nil
+}
+
+// UserIDs returns the participants of a chat in the order they joined
+func (c *MembershipCache) UserIDs(chatID uint) ([]uint, error) {
+	members, err := c.get(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	// callers own the result, the cached slice is shared
+	userIDs := make([]uint, len(members.userIDs))
+	copy(userIDs, members.userIDs)
+	return userIDs, nil
+}
+
+// Peek returns the cached participants of a chat and never goes to the database, so it
+// reports false for a chat that is not loaded. Entries past their TTL still count:
+// invalidations keep them current and the TTL only covers lost ones.
+func (c *MembershipCache) Peek(chatID uint) ([]uint, bool) {
+	c.mu.RLock()
+	members, ok := c.chats[chatID]
+	c.mu.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	userIDs := make([]uint, len(members.userIDs))
+	copy(userIDs, members.userIDs)
+	return userIDs, true
+}
+
+// Invalidate drops the chat here and tells the other instances to do the same
+func (c *MembershipCache) Invalidate(chatID uint) {
+	c.invalidate(chatID)
+
+	data, err := json.Marshal(chatID)
+	if err != nil {
+		log.Printf("failed to marshal membership invalidation: %v", err)
+		return
+	}
+
+	err = c.broker.Publish(&broker.Message{Topic: topicMembership, Data: data})
+	if err != nil {
+		log.Printf("failed to publish membership invalidation of chat %d: %v", chatID, err)
+	}
+}
+
+// handle applies invalidations published by any instance, this one included
+func (c *MembershipCache) handle(message *broker.Message) {
+	if message.Topic != topicMembership {
+		return
+	}
+
+	var chatID uint
+	if err := json.Unmarshal(message.Data, &chatID); err != nil {
+		log.Printf("failed to decode membership invalidation: %v", err)
+		return
+	}
+	c.invalidate(chatID)
+}
+
+func (c *MembershipCache) invalidate(chatID uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.chats, chatID)
+	c.generation++
+}
+
+func (c *MembershipCache) get(chatID uint) (*chatMembers, error) {
+	now := time.Now()
+
+	c.mu.RLock()
+	members, ok := c.chats[chatID]
+	generation := c.generation
+	c.mu.RUnlock()
+
+	if ok && now.Before(members.expiresAt) {
+		return members, nil
+	}
+
+	participants, err := c.chatParticipantsRepo.GetParticipantsByChatID(chatID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get chat participants: %w", err)
+	}
+
+	members = &chatMembers{
+		roles:     make(map[uint]model.ChatRole, len(participants)),
+		userIDs:   make([]uint, 0, len(participants)),
+		expiresAt: now.Add(membershipCacheTTL),
+	}
+	for _, participant := range participants {
+		members.roles[participant.UserID] = participant.Role
+		members.userIDs = append(members.userIDs, participant.UserID)
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		if len(c.chats) >= maxCachedChats {
+			c.evictExpired(now)
+		}
+		c.chats[chatID] = members
+	}
+	c.mu.Unlock()
+
+	return members, nil
+}
+
+// evictExpired makes room in a full cache, dropping everything if nothing has expired yet
+func (c *MembershipCache) evictExpired(now time.Time) {
+	for chatID, members := range c.chats {
+		if !now.Before(members.expiresAt) {
+			delete(c.chats, chatID)
+		}
+	}
+
+	if len(c.chats) >= maxCachedChats {
+		c.chats = make(map[uint]*chatMembers)
+	}
+}
@@ -3,23 +3,54 @@ package service
 import (
 	"errors"
 	"fmt"
+	"log"
 	"simpleMessenger/internal/model"
 	repoInterfaces "simpleMessenger/internal/repository/interfaces"
 	"strings"
 	"time"
+	"unicode/utf8"
+)
+
+const (
+	MessageEditWindow    = 48 * time.Hour
+	MaxClientMsgIDLength = 64
+	MaxEmojiLength       = 64
+	// MaxReactionsPerUser is how many different emojis one user may put on a message
+	MaxReactionsPerUser = 10
+	// replyPreviewLength is how many characters of the quoted text a reply carries
+	replyPreviewLength = 100
+)
+
+var (
+	ErrEmptyMessageText   = errors.New("message text cannot be empty")
+	ErrNotMessageAuthor   = errors.New("only the author can edit a message")
+	ErrEditWindowExpired  = fmt.Errorf("messages can only be edited within %s", MessageEditWindow)
+	ErrClientMsgIDTooLong = fmt.Errorf("client message id must be at most %d characters", MaxClientMsgIDLength)
+	ErrInvalidReplyTarget = errors.New("can only reply to an existing message of the same chat")
+	ErrInvalidThreadRoot  = errors.New("a thread can only start at an existing message of the same chat that is not in a thread itself")
+	ErrInvalidEmoji       = fmt.Errorf("emoji must be a single emoji or a :shortcode: of at most %d bytes", MaxEmojiLength)
+	ErrTooManyReactions   = fmt.Errorf("a user can put at most %d reactions on a message", MaxReactionsPerUser)
 )
 
 type MessageService struct {
 	messageRepo          repoInterfaces.MessageRepo
+	messageRevisionRepo  repoInterfaces.MessageRevisionRepo
+	attachmentRepo       repoInterfaces.AttachmentRepo
+	reactionRepo         repoInterfaces.ReactionRepo
 	chatRepo             repoInterfaces.ChatRepo
 	chatParticipantsRepo repoInterfaces.ChatParticipantsRepo
+	permissions          *permissionChecker
 }
 
-func NewMessageService(messageRepo repoInterfaces.MessageRepo, chatRepo repoInterfaces.ChatRepo, chatParticipantsRepo repoInterfaces.ChatParticipantsRepo) *MessageService {
+func NewMessageService(messageRepo repoInterfaces.MessageRepo, messageRevisionRepo repoInterfaces.MessageRevisionRepo, attachmentRepo repoInterfaces.AttachmentRepo, reactionRepo repoInterfaces.ReactionRepo, chatRepo repoInterfaces.ChatRepo, chatParticipantsRepo repoInterfaces.ChatParticipantsRepo, members *MembershipCache) *MessageService {
 	return &MessageService{
 		messageRepo:          messageRepo,
+		messageRevisionRepo:  messageRevisionRepo,
+		attachmentRepo:       attachmentRepo,
+		reactionRepo:         reactionRepo,
 		chatRepo:             chatRepo,
 		chatParticipantsRepo: chatParticipantsRepo,
+		permissions:          newPermissionChecker(members),
 	}
 }
 
@@ -27,6 +58,22 @@ type SendMessageRequest struct {
 	Text   string `json:"text"`
 	UserID uint   `json:"user_id"`
 	ChatID uint   `json:"chat_id"`
+	// ClientMsgID makes retries safe: a repeated request returns the message created by the first one
+	ClientMsgID string `json:"client_msg_id"`
+	// AttachmentIDs are uploads of the sender in this chat, with them Text may be empty
+	AttachmentIDs []uint `json:"attachment_ids"`
+	// ReplyToMessageID quotes a message of the same chat, zero for a plain message
+	ReplyToMessageID uint `json:"reply_to_message_id"`
+	// ThreadRootID posts the message into the thread under that root. It only shows up in
+	// the chat timeline as well if AlsoSendToChat is set.
+	ThreadRootID   uint `json:"thread_root_id"`
+	AlsoSendToChat bool `json:"also_send_to_chat"`
+}
+
+type EditMessageRequest struct {
+	Text      string `json:"text"`
+	UserID    uint   `json:"user_id"`
+	MessageID uint   `json:"message_id"`
 }
 
 type DeleteMessageRequest struct {
@@ -34,22 +81,57 @@ type DeleteMessageRequest struct {
 	MessageID uint `json:"message_id"`
 }
 
-func (s *MessageService) SendMessage(req *SendMessageRequest) (*model.Message, error) {
+// SendMessage stores a new message. The returned flag is false when the request
+// replays an earlier one and the original message is returned instead.
+func (s *MessageService) SendMessage(req *SendMessageRequest) (*model.Message, bool, error) {
 	if req == nil {
-		return nil, errors.New("nil request")
+		return nil, false, errors.New("nil request")
 	}
 
-	if strings.TrimSpace(req.Text) == "" {
-		return nil, errors.New("message text cannot be empty")
+	attachmentIDs := uniqueIDs(req.AttachmentIDs)
+
+	if strings.TrimSpace(req.Text) == "" && len(attachmentIDs) == 0 {
+		return nil, false, ErrEmptyMessageText
+	}
+
+	if len(attachmentIDs) > MaxAttachmentsPerMessage {
+		return nil, false, ErrTooManyAttachments
 	}
 
-	isUserInChat, err := s.chatParticipantsRepo.IsUserInChat(req.UserID, req.ChatID)
+	if len(req.ClientMsgID) > MaxClientMsgIDLength {
+		return nil, false, ErrClientMsgIDTooLong
+	}
+
+	if _, err := s.permissions.Require(req.UserID, req.ChatID, PermSendMessage); err != nil {
+		return nil, false, fmt.Errorf("cant send message: %w", err)
+	}
+
+	if req.ClientMsgID != "" {
+		original, err := s.messageRepo.GetByClientMsgID(req.UserID, req.ChatID, req.ClientMsgID)
+		if err == nil {
+			_ = s.loadReplyPreviews([]*model.Message{original})
+			return original, false, nil
+		}
+		if !errors.Is(err, repoInterfaces.ErrMessageNotFound) {
+			return nil, false, fmt.Errorf("cant check for duplicate message: %w", err)
+		}
+	}
+
+	attachments, err := s.getUnsentAttachments(attachmentIDs, req.UserID, req.ChatID)
 	if err != nil {
-		return nil, fmt.Errorf("cant check if user is in chat: %w", err)
+		return nil, false, err
 	}
 
-	if !isUserInChat {
-		return nil, fmt.Errorf("cant send message. user is not in chat")
+	if req.ReplyToMessageID != 0 {
+		if err := s.checkReplyTarget(req.ReplyToMessageID, req.ChatID); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if req.ThreadRootID != 0 {
+		if err := s.checkThreadRoot(req.ThreadRootID, req.ChatID); err != nil {
+			return nil, false, err
+		}
 	}
 
 	msg := &model.Message{
@@ -57,65 +139,890 @@ func (s *MessageService) SendMessage(req *SendMessageRequest) (*model.Message, e
 		ChatID: req.ChatID,
 		UserID: req.UserID,
 	}
+	if req.ClientMsgID != "" {
+		msg.ClientMsgID = &req.ClientMsgID
+	}
+	if req.ReplyToMessageID != 0 {
+		msg.ReplyToMessageID = &req.ReplyToMessageID
+	}
+	if req.ThreadRootID != 0 {
+		msg.ThreadRootID = &req.ThreadRootID
+		msg.AlsoSentToChat = req.AlsoSendToChat
+	}
 
-	err = s.messageRepo.Create(msg)
+	err = s.messageRepo.Create(msg, attachmentIDs)
 	if err != nil {
-		return nil, fmt.Errorf("cant send message: %w", err)
+		// a concurrent retry won the race for the idempotency key
+		if errors.Is(err, repoInterfaces.ErrMessageAlreadyExists) {
+			original, err := s.messageRepo.GetByClientMsgID(req.UserID, req.ChatID, req.ClientMsgID)
+			if err != nil {
+				return nil, false, fmt.Errorf("cant get original message: %w", err)
+			}
+			_ = s.loadReplyPreviews([]*model.Message{original})
+			return original, false, nil
+		}
+		if errors.Is(err, repoInterfaces.ErrAttachmentUnavailable) {
+			return nil, false, ErrInvalidAttachment
+		}
+		return nil, false, fmt.Errorf("cant send message: %w", err)
 	}
 
-	chat, err := s.chatRepo.GetByID(req.ChatID)
+	if len(attachments) > 0 {
+		for _, attachment := range attachments {
+			attachment.MessageID = &msg.ID
+		}
+		msg.Attachments = attachments
+	}
+
+	// a reply kept inside its thread leaves the read pointers as they are
+	if msg.InTimeline() {
+		// the author has obviously got and seen everything up to their own message
+		// the message is stored already, a stale pointer only delays the author's own receipts and unread count
+		if _, err := s.chatParticipantsRepo.AdvanceLastDelivered(req.UserID, req.ChatID, msg.ID); err != nil {
+			log.Printf("failed to advance delivery pointer of user %d in chat %d: %v", req.UserID, req.ChatID, err)
+		}
+		if _, err := s.chatParticipantsRepo.AdvanceLastRead(req.UserID, req.ChatID, msg.ID); err != nil {
+			log.Printf("failed to advance read pointer of user %d in chat %d: %v", req.UserID, req.ChatID, err)
+		}
+	}
+
+	// the message is stored already, a missing quote is not worth failing the send
+	_ = s.loadReplyPreviews([]*model.Message{msg})
+
+	return msg, true, nil
+}
 
+// checkReplyTarget makes sure a reply quotes a live message of its own chat
+func (s *MessageService) checkReplyTarget(messageID, chatID uint) error {
+	target, err := s.messageRepo.GetByID(messageID)
 	if err != nil {
-		_ = s.messageRepo.Delete(msg.ID)
-		return nil, fmt.Errorf("cant get chat for updating: %w", err)
+		if errors.Is(err, repoInterfaces.ErrMessageNotFound) {
+			return ErrInvalidReplyTarget
+		}
+		return fmt.Errorf("cant get replied message: %w", err)
 	}
 
-	chat.LastMessageAt = time.Now()
+	if target.ChatID != chatID {
+		return ErrInvalidReplyTarget
+	}
+	return nil
+}
 
-	err = s.chatRepo.Update(chat)
+// checkThreadRoot makes sure a thread starts at a live message of the chat. Threads don't
+// nest, a reply in a thread can't be a root itself.
+func (s *MessageService) checkThreadRoot(rootID, chatID uint) error {
+	root, err := s.messageRepo.GetByID(rootID)
+	if err != nil {
+		if errors.Is(err, repoInterfaces.ErrMessageNotFound) {
+			return ErrInvalidThreadRoot
+		}
+		return fmt.Errorf("cant get thread root: %w", err)
+	}
 
+	if root.ChatID != chatID || root.ThreadRootID != nil {
+		return ErrInvalidThreadRoot
+	}
+	return nil
+}
+
+// loadReplyPreviews fills in the quoted message of every reply among messages
+func (s *MessageService) loadReplyPreviews(messages []*model.Message) error {
+	var ids []uint
+	for _, message := range messages {
+		if message.ReplyToMessageID != nil {
+			ids = append(ids, *message.ReplyToMessageID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	previews, err := s.messageRepo.GetPreviews(uniqueIDs(ids))
 	if err != nil {
-		_ = s.messageRepo.Delete(msg.ID)
-		return nil, fmt.Errorf("cant update last_message_at in chat: %w", err)
+		return fmt.Errorf("cant get quoted messages: %w", err)
 	}
 
-	return msg, nil
+	byID := make(map[uint]*model.MessagePreview, len(previews))
+	for _, preview := range previews {
+		if preview.Deleted {
+			preview.Text = ""
+		} else {
+			preview.Text = snippet(preview.Text, replyPreviewLength)
+		}
+		byID[preview.MessageID] = preview
+	}
+
+	for _, message := range messages {
+		if message.ReplyToMessageID != nil {
+			message.ReplyTo = byID[*message.ReplyToMessageID]
+		}
+	}
+	return nil
+}
+
+// snippet cuts text to at most length characters, marking the cut with an ellipsis
+func snippet(text string, length int) string {
+	text = strings.TrimSpace(text)
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:length-1])) + "…"
+}
+
+// getUnsentAttachments loads attachments the user uploaded into the chat and has not sent yet
+func (s *MessageService) getUnsentAttachments(ids []uint, userID, chatID uint) ([]*model.Attachment, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	attachments, err := s.attachmentRepo.GetByIDs(ids)
+	if err != nil {
+		return nil, fmt.Errorf("cant get attachments: %w", err)
+	}
+
+	if len(attachments) != len(ids) {
+		return nil, ErrInvalidAttachment
+	}
+
+	for _, attachment := range attachments {
+		if attachment.UploaderID != userID || attachment.ChatID != chatID || attachment.MessageID != nil {
+			return nil, ErrInvalidAttachment
+		}
+	}
+
+	return attachments, nil
+}
+
+type GetMessagesRequest struct {
+	ChatID uint
+	UserID uint
+	Limit  int
+	// at most one of Before, After and AroundMessageID may be set
+	Before          string
+	After           string
+	AroundMessageID uint
+	// ThreadRootID pages the replies of a thread instead of the chat timeline, it can't be
+	// combined with AroundMessageID
+	ThreadRootID uint
+}
+
+// MessagePage is a window of a chat history in ascending order. PrevCursor loads older
+// messages when passed as Before, NextCursor loads newer ones when passed as After.
+type MessagePage struct {
+	Messages      []*model.Message `json:"messages"`
+	PrevCursor    string           `json:"prevCursor,omitempty"`
+	NextCursor    string           `json:"nextCursor,omitempty"`
+	HasMoreBefore bool             `json:"hasMoreBefore"`
+	HasMoreAfter  bool             `json:"hasMoreAfter"`
+}
+
+func (s *MessageService) GetMessages(req *GetMessagesRequest) (*MessagePage, error) {
+	if req == nil {
+		return nil, errors.New("nil request")
+	}
+
+	modes := 0
+	for _, set := range []bool{req.Before != "", req.After != "", req.AroundMessageID != 0} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 || (req.ThreadRootID != 0 && req.AroundMessageID != 0) {
+		return nil, ErrInvalidCursor
+	}
+
+	if _, err := s.permissions.Require(req.UserID, req.ChatID, PermReadMessages); err != nil {
+		return nil, fmt.Errorf("cant get messages: %w", err)
+	}
+
+	var (
+		page *MessagePage
+		err  error
+	)
+
+	switch {
+	case req.AroundMessageID != 0:
+		page, err = s.getMessagesAround(req.ChatID, req.AroundMessageID, req.Limit)
+	case req.Before != "":
+		page, err = s.getMessagesBefore(req.ChatID, req.ThreadRootID, req.Before, req.Limit)
+	case req.After != "":
+		page, err = s.getMessagesAfter(req.ChatID, req.ThreadRootID, req.After, req.Limit)
+	default:
+		page, err = s.getLatestMessages(req.ChatID, req.ThreadRootID, req.Limit)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.loadReactions(page.Messages, req.UserID); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadReplyPreviews(page.Messages); err != nil {
+		return nil, err
+	}
+
+	if len(page.Messages) > 0 {
+		page.PrevCursor = EncodeMessageCursor(page.Messages[0])
+		page.NextCursor = EncodeMessageCursor(page.Messages[len(page.Messages)-1])
+	}
+
+	return page, nil
 }
 
-func (s *MessageService) GetMessages(chatID uint, limit int, userID uint) ([]*model.Message, error) {
-	isUserInChat, err := s.chatParticipantsRepo.IsUserInChat(userID, chatID)
+func (s *MessageService) getLatestMessages(chatID, threadRootID uint, limit int) (*MessagePage, error) {
+	messages, err := s.messageRepo.GetMessagesByChatID(chatID, repoInterfaces.MessagePageQuery{Limit: limit + 1, ThreadRootID: threadRootID})
+	if err != nil {
+		return nil, fmt.Errorf("cant get chat messages in chat: %w", err)
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[1:]
+	}
+
+	return &MessagePage{Messages: messages, HasMoreBefore: hasMore}, nil
+}
+
+func (s *MessageService) getMessagesBefore(chatID, threadRootID uint, before string, limit int) (*MessagePage, error) {
+	cursor, err := DecodeMessageCursor(before)
+	if err != nil {
+		return nil, err
+	}
 
+	messages, err := s.messageRepo.GetMessagesByChatID(chatID, repoInterfaces.MessagePageQuery{Before: cursor, Limit: limit + 1, ThreadRootID: threadRootID})
 	if err != nil {
-		return nil, fmt.Errorf("cant check if user is in chat: %w", err)
+		return nil, fmt.Errorf("cant get chat messages in chat: %w", err)
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[1:]
 	}
 
-	if !isUserInChat {
-		return nil, fmt.Errorf("cant get messages. user is not in chat")
+	page := &MessagePage{Messages: messages, HasMoreBefore: hasMore, HasMoreAfter: true}
+	if len(messages) == 0 {
+		page.NextCursor = before
 	}
+	return page, nil
+}
 
-	messages, err := s.messageRepo.GetMessagesByChatID(chatID, limit)
+func (s *MessageService) getMessagesAfter(chatID, threadRootID uint, after string, limit int) (*MessagePage, error) {
+	cursor, err := DecodeMessageCursor(after)
+	if err != nil {
+		return nil, err
+	}
 
+	messages, err := s.messageRepo.GetMessagesByChatID(chatID, repoInterfaces.MessagePageQuery{After: cursor, Limit: limit + 1, ThreadRootID: threadRootID})
 	if err != nil {
 		return nil, fmt.Errorf("cant get chat messages in chat: %w", err)
 	}
 
-	return messages, nil
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	page := &MessagePage{Messages: messages, HasMoreBefore: true, HasMoreAfter: hasMore}
+	if len(messages) == 0 {
+		// nothing new yet, the client keeps polling from the same position
+		page.NextCursor = after
+	}
+	return page, nil
+}
+
+// getMessagesAround returns the target message centered between older and newer ones
+func (s *MessageService) getMessagesAround(chatID, messageID uint, limit int) (*MessagePage, error) {
+	target, err := s.messageRepo.GetByID(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get message: %w", err)
+	}
+
+	if target.ChatID != chatID {
+		return nil, repoInterfaces.ErrMessageNotFound
+	}
+
+	cursor := messageCursor(target)
+	olderLimit := (limit - 1) / 2
+	newerLimit := limit - 1 - olderLimit
+
+	older, err := s.messageRepo.GetMessagesByChatID(chatID, repoInterfaces.MessagePageQuery{Before: cursor, Limit: olderLimit + 1})
+	if err != nil {
+		return nil, fmt.Errorf("cant get older messages: %w", err)
+	}
+
+	newer, err := s.messageRepo.GetMessagesByChatID(chatID, repoInterfaces.MessagePageQuery{After: cursor, Limit: newerLimit + 1})
+	if err != nil {
+		return nil, fmt.Errorf("cant get newer messages: %w", err)
+	}
+
+	page := &MessagePage{
+		HasMoreBefore: len(older) > olderLimit,
+		HasMoreAfter:  len(newer) > newerLimit,
+	}
+	if page.HasMoreBefore {
+		older = older[1:]
+	}
+	if page.HasMoreAfter {
+		newer = newer[:newerLimit]
+	}
+
+	page.Messages = make([]*model.Message, 0, len(older)+1+len(newer))
+	page.Messages = append(page.Messages, older...)
+	page.Messages = append(page.Messages, target)
+	page.Messages = append(page.Messages, newer...)
+
+	return page, nil
+}
+
+type GetThreadRequest struct {
+	RootMessageID uint
+	UserID        uint
+	Limit         int
+	// at most one of Before and After may be set
+	Before string
+	After  string
+}
+
+// ThreadPage is a window of the replies in a thread together with the message they answer
+type ThreadPage struct {
+	Root *model.Message `json:"root"`
+	*MessagePage
+}
+
+func (s *MessageService) GetThread(req *GetThreadRequest) (*ThreadPage, error) {
+	if req == nil {
+		return nil, errors.New("nil request")
+	}
+
+	root, err := s.messageRepo.GetByID(req.RootMessageID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get thread root: %w", err)
+	}
+
+	if root.ThreadRootID != nil {
+		return nil, ErrInvalidThreadRoot
+	}
+
+	page, err := s.GetMessages(&GetMessagesRequest{
+		ChatID:       root.ChatID,
+		UserID:       req.UserID,
+		Limit:        req.Limit,
+		Before:       req.Before,
+		After:        req.After,
+		ThreadRootID: root.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	roots := []*model.Message{root}
+	if err := s.loadReactions(roots, req.UserID); err != nil {
+		return nil, err
+	}
+	if err := s.loadReplyPreviews(roots); err != nil {
+		return nil, err
+	}
+
+	return &ThreadPage{Root: root, MessagePage: page}, nil
+}
+
+// GetThreadSummary returns the reply counters of a thread root
+func (s *MessageService) GetThreadSummary(rootID uint) (*model.ThreadSummary, error) {
+	root, err := s.messageRepo.GetByID(rootID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get thread root: %w", err)
+	}
+
+	return &model.ThreadSummary{
+		ChatID:        root.ChatID,
+		RootMessageID: root.ID,
+		ReplyCount:    root.ThreadReplyCount,
+		LastReplyAt:   root.ThreadLastReplyAt,
+	}, nil
+}
+
+// GetThreadParticipants returns who is notified about a thread: the author of the root and
+// everyone who replied, as long as they are still in the chat
+func (s *MessageService) GetThreadParticipants(rootID, chatID uint) ([]uint, error) {
+	userIDs, err := s.messageRepo.GetThreadParticipants(rootID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get thread participants: %w", err)
+	}
+
+	members, err := s.permissions.members.UserIDs(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	inChat := make(map[uint]bool, len(members))
+	for _, userID := range members {
+		inChat[userID] = true
+	}
+
+	participants := make([]uint, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if inChat[userID] {
+			participants = append(participants, userID)
+		}
+	}
+	return participants, nil
+}
+
+// loadReactions fills in the reaction counts of messages as the user sees them
+func (s *MessageService) loadReactions(messages []*model.Message, userID uint) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	messageIDs := make([]uint, len(messages))
+	for i, message := range messages {
+		messageIDs[i] = message.ID
+	}
+
+	counts, err := s.reactionRepo.CountByMessageIDs(messageIDs, userID)
+	if err != nil {
+		return fmt.Errorf("cant get reactions: %w", err)
+	}
+
+	for _, message := range messages {
+		message.Reactions = counts[message.ID]
+	}
+	return nil
+}
+
+// AddReaction puts an emoji on a message. The returned update is nil when the user had
+// already reacted with it, so there is nothing to announce.
+func (s *MessageService) AddReaction(messageID, userID uint, emoji string) (*model.ReactionUpdate, error) {
+	msg, err := s.getReactableMessage(messageID, userID, emoji)
+	if err != nil {
+		return nil, err
+	}
+
+	added, err := s.reactionRepo.Add(&model.Reaction{MessageID: msg.ID, UserID: userID, Emoji: emoji}, msg.ChatID, MaxReactionsPerUser)
+	if err != nil {
+		if errors.Is(err, repoInterfaces.ErrTooManyReactions) {
+			return nil, ErrTooManyReactions
+		}
+		return nil, fmt.Errorf("cant add reaction: %w", err)
+	}
+
+	if !added {
+		return nil, nil
+	}
+
+	return s.newReactionUpdate(msg, userID, emoji, true)
 }
 
-func (s *MessageService) DeleteMessage(messageID, userID uint) error {
+// RemoveReaction takes an emoji of the user off a message, the update is nil if it wasn't there
+func (s *MessageService) RemoveReaction(messageID, userID uint, emoji string) (*model.ReactionUpdate, error) {
+	msg, err := s.getReactableMessage(messageID, userID, emoji)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := s.reactionRepo.Remove(msg.ID, msg.ChatID, userID, emoji)
+	if err != nil {
+		return nil, fmt.Errorf("cant remove reaction: %w", err)
+	}
+
+	if !removed {
+		return nil, nil
+	}
+
+	return s.newReactionUpdate(msg, userID, emoji, false)
+}
+
+func (s *MessageService) getReactableMessage(messageID, userID uint, emoji string) (*model.Message, error) {
+	if !isValidEmoji(emoji) {
+		return nil, ErrInvalidEmoji
+	}
+
 	msg, err := s.messageRepo.GetByID(messageID)
 	if err != nil {
-		return fmt.Errorf("cant get message: %w", err)
+		return nil, fmt.Errorf("cant get message: %w", err)
+	}
+
+	// any participant may react, including those who can't send messages
+	if _, err := s.permissions.Require(userID, msg.ChatID, PermReadMessages); err != nil {
+		return nil, fmt.Errorf("cant react to message: %w", err)
+	}
+
+	return msg, nil
+}
+
+func (s *MessageService) newReactionUpdate(msg *model.Message, userID uint, emoji string, added bool) (*model.ReactionUpdate, error) {
+	count, err := s.reactionRepo.CountByEmoji(msg.ID, emoji)
+	if err != nil {
+		return nil, fmt.Errorf("cant count reactions: %w", err)
+	}
+
+	return &model.ReactionUpdate{
+		MessageID: msg.ID,
+		ChatID:    msg.ChatID,
+		UserID:    userID,
+		Emoji:     emoji,
+		Added:     added,
+		Count:     count,
+	}, nil
+}
+
+// DeleteMessage soft-deletes a message and returns it so callers can notify the chat
+func (s *MessageService) DeleteMessage(messageID, userID uint) (*model.Message, error) {
+	msg, err := s.messageRepo.GetByID(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get message: %w", err)
 	}
 
 	if msg.UserID != userID {
-		return fmt.Errorf("cant delete another user's message")
+		if _, err := s.permissions.Require(userID, msg.ChatID, PermDeleteAnyMessage); err != nil {
+			return nil, fmt.Errorf("cant delete another user's message: %w", err)
+		}
 	}
 
 	err = s.messageRepo.Delete(messageID)
 	if err != nil {
-		return fmt.Errorf("cant delete message: %w", err)
+		return nil, fmt.Errorf("cant delete message: %w", err)
 	}
 
-	return nil
+	return msg, nil
+}
+
+func (s *MessageService) EditMessage(req *EditMessageRequest) (*model.Message, error) {
+	if req == nil {
+		return nil, errors.New("nil request")
+	}
+
+	if strings.TrimSpace(req.Text) == "" {
+		return nil, ErrEmptyMessageText
+	}
+
+	msg, err := s.messageRepo.GetByID(req.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get message: %w", err)
+	}
+
+	if msg.UserID != req.UserID {
+		return nil, ErrNotMessageAuthor
+	}
+
+	if time.Since(msg.CreatedAt) > MessageEditWindow {
+		return nil, ErrEditWindowExpired
+	}
+
+	if _, err := s.permissions.Require(req.UserID, msg.ChatID, PermSendMessage); err != nil {
+		return nil, fmt.Errorf("cant edit message: %w", err)
+	}
+
+	// the edited message is pushed to the chat again, quote included
+	_ = s.loadReplyPreviews([]*model.Message{msg})
+
+	if msg.Text == req.Text {
+		return msg, nil
+	}
+
+	// the repo keeps the previous text as a revision in the same transaction
+	editedAt := time.Now()
+	msg.Text = req.Text
+	msg.EditedAt = &editedAt
+
+	err = s.messageRepo.Edit(msg)
+	if err != nil {
+		return nil, fmt.Errorf("cant edit message: %w", err)
+	}
+
+	return msg, nil
+}
+
+func (s *MessageService) GetMessageHistory(messageID, userID uint) ([]*model.MessageRevision, error) {
+	msg, err := s.messageRepo.GetByID(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get message: %w", err)
+	}
+
+	if _, err := s.permissions.Require(userID, msg.ChatID, PermReadMessages); err != nil {
+		return nil, fmt.Errorf("cant get message history: %w", err)
+	}
+
+	revisions, err := s.messageRevisionRepo.GetByMessageID(msg.ID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get message history: %w", err)
+	}
+
+	return revisions, nil
+}
+
+// MarkDelivered is called when a client confirms it has received messages of a chat up to
+// messageID. The returned receipt is nil when nothing new was delivered.
+func (s *MessageService) MarkDelivered(chatID, userID, messageID uint) (*model.DeliveryReceipt, error) {
+	if _, err := s.permissions.Require(userID, chatID, PermReadMessages); err != nil {
+		return nil, fmt.Errorf("cant mark messages as delivered: %w", err)
+	}
+
+	msg, err := s.messageRepo.GetByID(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get message: %w", err)
+	}
+
+	if msg.ChatID != chatID {
+		return nil, repoInterfaces.ErrMessageNotFound
+	}
+
+	advanced, err := s.chatParticipantsRepo.AdvanceLastDelivered(userID, chatID, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("cant mark messages as delivered: %w", err)
+	}
+
+	if !advanced {
+		return nil, nil
+	}
+
+	return &model.DeliveryReceipt{
+		ChatID:                 chatID,
+		UserID:                 userID,
+		LastDeliveredMessageID: messageID,
+		DeliveredAt:            time.Now(),
+	}, nil
+}
+
+// MarkRead moves the caller's read pointer in a chat up to messageID, and the delivery
+// pointer with it. Each receipt is nil when its pointer was already at or past that
+// message, so there is nothing to announce.
+func (s *MessageService) MarkRead(chatID, userID, messageID uint) (*model.ReadReceipt, *model.DeliveryReceipt, error) {
+	if _, err := s.permissions.Require(userID, chatID, PermReadMessages); err != nil {
+		return nil, nil, fmt.Errorf("cant mark messages as read: %w", err)
+	}
+
+	msg, err := s.messageRepo.GetByID(messageID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cant get message: %w", err)
+	}
+
+	if msg.ChatID != chatID {
+		return nil, nil, repoInterfaces.ErrMessageNotFound
+	}
+
+	now := time.Now()
+
+	// a message can't be read before it has arrived
+	delivered, err := s.chatParticipantsRepo.AdvanceLastDelivered(userID, chatID, messageID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cant mark messages as delivered: %w", err)
+	}
+
+	var delivery *model.DeliveryReceipt
+	if delivered {
+		delivery = &model.DeliveryReceipt{
+			ChatID:                 chatID,
+			UserID:                 userID,
+			LastDeliveredMessageID: messageID,
+			DeliveredAt:            now,
+		}
+	}
+
+	advanced, err := s.chatParticipantsRepo.AdvanceLastRead(userID, chatID, messageID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cant mark messages as read: %w", err)
+	}
+
+	if !advanced {
+		return nil, delivery, nil
+	}
+
+	return &model.ReadReceipt{
+		ChatID:            chatID,
+		UserID:            userID,
+		LastReadMessageID: messageID,
+		ReadAt:            now,
+	}, delivery, nil
+}
+
+// GetMessageReaders lists the participants, other than the author, who have read a message
+func (s *MessageService) GetMessageReaders(messageID, userID uint) ([]*model.ReadReceipt, error) {
+	msg, err := s.messageRepo.GetByID(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get message: %w", err)
+	}
+
+	if _, err := s.permissions.Require(userID, msg.ChatID, PermReadMessages); err != nil {
+		return nil, fmt.Errorf("cant get message readers: %w", err)
+	}
+
+	receipts, err := s.chatParticipantsRepo.GetReaders(msg.ChatID, msg.ID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get message readers: %w", err)
+	}
+
+	readers := make([]*model.ReadReceipt, 0, len(receipts))
+	for _, receipt := range receipts {
+		if receipt.UserID != msg.UserID {
+			readers = append(readers, receipt)
+		}
+	}
+
+	return readers, nil
+}
+
+// MessageDeliveryStatus is the overall state of a message and its state for every other participant.
+// The message is delivered or read only once that holds for all recipients.
+type MessageDeliveryStatus struct {
+	MessageID  uint                     `json:"messageId"`
+	Status     model.MessageStatus      `json:"status"`
+	Recipients []*model.RecipientStatus `json:"recipients"`
+}
+
+func (s *MessageService) GetMessageStatus(messageID, userID uint) (*MessageDeliveryStatus, error) {
+	msg, err := s.messageRepo.GetByID(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get message: %w", err)
+	}
+
+	if _, err := s.permissions.Require(userID, msg.ChatID, PermReadMessages); err != nil {
+		return nil, fmt.Errorf("cant get message status: %w", err)
+	}
+
+	participants, err := s.chatParticipantsRepo.GetParticipantsByChatID(msg.ChatID)
+	if err != nil {
+		return nil, fmt.Errorf("cant get chat participants: %w", err)
+	}
+
+	result := &MessageDeliveryStatus{
+		MessageID:  msg.ID,
+		Status:     model.MessageStatusRead,
+		Recipients: make([]*model.RecipientStatus, 0, len(participants)),
+	}
+
+	for _, participant := range participants {
+		if participant.UserID == msg.UserID {
+			continue
+		}
+
+		recipient := &model.RecipientStatus{UserID: participant.UserID, Status: model.MessageStatusSent}
+		if participant.LastDeliveredMessageID != nil && *participant.LastDeliveredMessageID >= msg.ID {
+			recipient.Status = model.MessageStatusDelivered
+			recipient.DeliveredAt = participant.LastDeliveredAt
+		}
+		if participant.LastReadMessageID != nil && *participant.LastReadMessageID >= msg.ID {
+			recipient.Status = model.MessageStatusRead
+			recipient.ReadAt = participant.LastReadAt
+		}
+
+		result.Status = lowestStatus(result.Status, recipient.Status)
+		result.Recipients = append(result.Recipients, recipient)
+	}
+
+	if len(result.Recipients) == 0 {
+		result.Status = model.MessageStatusSent
+	}
+
+	return result, nil
+}
+
+func lowestStatus(a, b model.MessageStatus) model.MessageStatus {
+	rank := map[model.MessageStatus]int{
+		model.MessageStatusSent:      0,
+		model.MessageStatusDelivered: 1,
+		model.MessageStatusRead:      2,
+	}
+	if rank[a] < rank[b] {
+		return a
+	}
+	return b
+}
+
+// MaxSyncChanges caps how many changed messages one sync call replays
+const MaxSyncChanges = 500
+
+// ChatChanges lists what happened in a chat after the sequence number a client reported
+type ChatChanges struct {
+	ChatID uint
+	// Since is the sequence the client reported, LastSeq the one it should report next time
+	Since   uint64
+	LastSeq uint64
+	// New is set for chats the client did not know about, their history is not replayed
+	New      bool
+	Messages []*model.Message
+}
+
+type SyncResult struct {
+	Chats []*ChatChanges
+	// RemovedChatIDs are reported chats the user is no longer a participant of
+	RemovedChatIDs []uint
+	// HasMore means the replay was cut at MaxSyncChanges and the client should sync again
+	HasMore bool
+}
+
+// Sync collects every change to messages in the user's chats newer than the sequence
+// numbers in since, which maps chat ids to the last sequence the client has seen
+func (s *MessageService) Sync(userID uint, since map[uint]uint64) (*SyncResult, error) {
+	chats, err := s.chatRepo.GetChats(userID, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cant get chats for sync: %w", err)
+	}
+
+	result := &SyncResult{}
+	budget := MaxSyncChanges
+	current := make(map[uint]bool, len(chats))
+
+	for _, chat := range chats {
+		// the chat list is not trusted on its own, history is only replayed to current members
+		if _, err := s.permissions.Require(userID, chat.ID, PermReadMessages); err != nil {
+			if errors.Is(err, ErrUserNotInChat) {
+				continue
+			}
+			return nil, fmt.Errorf("cant check membership for sync: %w", err)
+		}
+		current[chat.ID] = true
+
+		seq, known := since[chat.ID]
+		if !known {
+			result.Chats = append(result.Chats, &ChatChanges{ChatID: chat.ID, LastSeq: chat.LastSeq, New: true})
+			continue
+		}
+
+		if chat.LastSeq <= seq {
+			continue
+		}
+
+		if budget == 0 {
+			result.HasMore = true
+			continue
+		}
+
+		messages, err := s.messageRepo.GetChangedSince(chat.ID, seq, budget+1)
+		if err != nil {
+			return nil, fmt.Errorf("cant get changes in chat %d: %w", chat.ID, err)
+		}
+
+		changes := &ChatChanges{ChatID: chat.ID, Since: seq, LastSeq: chat.LastSeq}
+		if len(messages) > budget {
+			messages = messages[:budget]
+			// the client resumes right after the last change it got
+			changes.LastSeq = messages[len(messages)-1].Seq
+			result.HasMore = true
+		}
+		budget -= len(messages)
+
+		if err := s.loadReplyPreviews(messages); err != nil {
+			return nil, err
+		}
+		// reactions move a message's seq too, a replayed message carries the current counts
+		if err := s.loadReactions(messages, userID); err != nil {
+			return nil, err
+		}
+
+		changes.Messages = messages
+		result.Chats = append(result.Chats, changes)
+	}
+
+	for chatID := range since {
+		if !current[chatID] {
+			result.RemovedChatIDs = append(result.RemovedChatIDs, chatID)
+		}
+	}
+
+	return result, nil
 }
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"gorm.io/gorm"
+	"simpleMessenger/internal/broker"
+	"simpleMessenger/internal/model"
+	repoInterfaces "simpleMessenger/internal/repository/interfaces"
+	"testing"
+)
+
+// the fakes embed their interface, calling anything a test did not expect panics
+
+type fakeChatRepo struct {
+	repoInterfaces.ChatRepo
+	chats []*model.Chat
+}
+
+func (r *fakeChatRepo) GetChats(userID uint, limit int) ([]*model.Chat, error) {
+	return r.chats, nil
+}
+
+type fakeParticipantsRepo struct {
+	repoInterfaces.ChatParticipantsRepo
+	participants map[uint][]*model.ChatParticipants
+}
+
+func (r *fakeParticipantsRepo) GetParticipantsByChatID(chatID uint) ([]*model.ChatParticipants, error) {
+	return r.participants[chatID], nil
+}
+
+type fakeMessageRepo struct {
+	repoInterfaces.MessageRepo
+	messages []*model.Message
+}
+
+func (r *fakeMessageRepo) GetChangedSince(chatID uint, seq uint64, limit int) ([]*model.Message, error) {
+	var changed []*model.Message
+	for _, message := range r.messages {
+		if message.ChatID == chatID && message.Seq > seq {
+			changed = append(changed, message)
+		}
+	}
+	return changed, nil
+}
+
+func (r *fakeMessageRepo) GetPreviews(ids []uint) ([]*model.MessagePreview, error) {
+	return nil, nil
+}
+
+type fakeReactionRepo struct {
+	repoInterfaces.ReactionRepo
+	counts map[uint][]*model.ReactionCount
+}
+
+func (r *fakeReactionRepo) CountByMessageIDs(messageIDs []uint, viewerID uint) (map[uint][]*model.ReactionCount, error) {
+	return r.counts, nil
+}
+
+func gormModel(id uint) gorm.Model {
+	return gorm.Model{ID: id}
+}
+
+func newSyncTestService(chats []*model.Chat, participants map[uint][]*model.ChatParticipants, messages []*model.Message) *MessageService {
+	participantsRepo := &fakeParticipantsRepo{participants: participants}
+	return NewMessageService(
+		&fakeMessageRepo{messages: messages},
+		nil,
+		nil,
+		&fakeReactionRepo{counts: map[uint][]*model.ReactionCount{10: {{Emoji: ":thumbsup:", Count: 1}}}},
+		&fakeChatRepo{chats: chats},
+		participantsRepo,
+		NewMembershipCache(participantsRepo, broker.NewMemoryBroker()),
+	)
+}
+
+func TestSyncReplaysNewMessagesToMembers(t *testing.T) {
+	service := newSyncTestService(
+		[]*model.Chat{{Model: gormModel(1), LastSeq: 2}},
+		map[uint][]*model.ChatParticipants{1: {{ChatID: 1, UserID: 1, Role: model.ChatRoleMember}}},
+		[]*model.Message{{Model: gormModel(10), ChatID: 1, Seq: 2, CreatedSeq: 2}},
+	)
+
+	result, err := service.Sync(1, map[uint]uint64{1: 1})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	if len(result.Chats) != 1 || len(result.Chats[0].Messages) != 1 {
+		t.Fatalf("Sync() chats = %+v, want one chat with one message", result.Chats)
+	}
+	if reactions := result.Chats[0].Messages[0].Reactions; len(reactions) != 1 {
+		t.Errorf("Sync() reactions = %+v, want the current counts", reactions)
+	}
+	if len(result.RemovedChatIDs) != 0 {
+		t.Errorf("Sync() removed = %v, want none", result.RemovedChatIDs)
+	}
+}
+
+func TestSyncSkipsChatsOfRemovedMembers(t *testing.T) {
+	// the chat list still has the chat, as it did while soft-deleted participants leaked into it
+	service := newSyncTestService(
+		[]*model.Chat{{Model: gormModel(1), LastSeq: 2}},
+		map[uint][]*model.ChatParticipants{1: {{ChatID: 1, UserID: 1, Role: model.ChatRoleOwner}}},
+		[]*model.Message{{Model: gormModel(10), ChatID: 1, Seq: 2, CreatedSeq: 2}},
+	)
+
+	for name, since := range map[string]map[uint]uint64{
+		"known chat":   {1: 1},
+		"unknown chat": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			result, err := service.Sync(2, since)
+			if err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+
+			if len(result.Chats) != 0 {
+				t.Errorf("Sync() chats = %+v, want none", result.Chats)
+			}
+
+			_, reported := since[1]
+			if reported && (len(result.RemovedChatIDs) != 1 || result.RemovedChatIDs[0] != 1) {
+				t.Errorf("Sync() removed = %v, want [1]", result.RemovedChatIDs)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	if got := snippet("  hello  ", 5); got != "hello" {
+		t.Errorf("snippet() = %q, want the trimmed text when it fits", got)
+	}
+
+	// the cut keeps room for the ellipsis and leaves no space dangling before it
+	cuts := map[string]string{
+		"hello world": "hello…",
+		"hello wörld": "hello…",
+		"привет мир":  "привет…",
+	}
+	for text, want := range cuts {
+		if got := snippet(text, 7); got != want {
+			t.Errorf("snippet(%q, 7) = %q, want %q", text, got, want)
+		}
+	}
+
+	if got := snippet("hello world", 8); got != "hello w…" {
+		t.Errorf("snippet() = %q, want %q", got, "hello w…")
+	}
+}
@@ -1,35 +1,203 @@
 package service
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"simpleMessenger/internal/model"
+	repoInterfaces "simpleMessenger/internal/repository/interfaces"
 	"time"
 )
 
 const (
-	AccessTokenExpireDuration = 3600
+	AccessTokenExpireDuration  = 3600
+	RefreshTokenExpireDuration = 30 * 24 * 3600
+	refreshTokenBytes          = 32
 )
 
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrSessionRevoked      = errors.New("session is revoked or expired")
+)
+
+type TokenPair struct {
+	UserID           uint
+	AccessToken      string
+	RefreshToken     string
+	ExpiresIn        int64
+	RefreshExpiresIn int64
+}
+
 type jwtService struct {
-	secretKey string
+	secretKey   string
+	sessionRepo repoInterfaces.SessionRepo
 }
 
 type TokenService interface {
-	GenerateToken(userID uint) (string, error)
+	// IssueTokens starts a new session for the user and returns its first token pair
+	IssueTokens(userID uint) (*TokenPair, error)
+	// RefreshTokens rotates the refresh token of a session and mints a new access token
+	RefreshTokens(refreshToken string) (*TokenPair, error)
+	// VerifyToken checks the access token signature and that its session is still active
 	VerifyToken(tokenString string) (uint, error)
+	RevokeSession(refreshToken string) error
+	RevokeAllSessions(userID uint) error
 }
 
-func NewJwtService(secretKey string) TokenService {
-	return &jwtService{secretKey: secretKey}
+func NewJwtService(secretKey string, sessionRepo repoInterfaces.SessionRepo) TokenService {
+	return &jwtService{secretKey: secretKey, sessionRepo: sessionRepo}
 }
 
 type Claims struct {
-	UserID uint `json:"user_id"`
+	UserID    uint `json:"user_id"`
+	SessionID uint `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-func (s *jwtService) GenerateToken(userID uint) (string, error) {
+func (s *jwtService) IssueTokens(userID uint) (*TokenPair, error) {
+	refreshToken, refreshHash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &model.Session{
+		UserID:           userID,
+		RefreshTokenHash: refreshHash,
+		ExpiresAt:        time.Now().Add(time.Second * RefreshTokenExpireDuration),
+	}
+
+	err = s.sessionRepo.Create(session)
+	if err != nil {
+		return nil, fmt.Errorf("cant create session: %w", err)
+	}
+
+	accessToken, err := s.generateAccessToken(userID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		UserID:           userID,
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		ExpiresIn:        AccessTokenExpireDuration,
+		RefreshExpiresIn: RefreshTokenExpireDuration,
+	}, nil
+}
+
+func (s *jwtService) RefreshTokens(refreshToken string) (*TokenPair, error) {
+	hash := hashRefreshToken(refreshToken)
+
+	session, err := s.sessionRepo.GetByRefreshTokenHash(hash)
+	if err != nil {
+		if errors.Is(err, repoInterfaces.ErrSessionNotFound) {
+			// a rotated token being presented again means it leaked, so the whole session is dropped
+			if reused, err := s.sessionRepo.GetByPreviousTokenHash(hash); err == nil {
+				_ = s.sessionRepo.Revoke(reused.ID)
+			}
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, fmt.Errorf("cant get session: %w", err)
+	}
+
+	if !session.IsActive(time.Now()) {
+		return nil, ErrSessionRevoked
+	}
+
+	newToken, newHash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session.PreviousTokenHash = session.RefreshTokenHash
+	session.RefreshTokenHash = newHash
+	session.ExpiresAt = time.Now().Add(time.Second * RefreshTokenExpireDuration)
+
+	err = s.sessionRepo.Rotate(session, hash)
+	if err != nil {
+		// another refresh with the same token got there first
+		if errors.Is(err, repoInterfaces.ErrSessionNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, fmt.Errorf("cant rotate refresh token: %w", err)
+	}
+
+	accessToken, err := s.generateAccessToken(session.UserID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		UserID:           session.UserID,
+		AccessToken:      accessToken,
+		RefreshToken:     newToken,
+		ExpiresIn:        AccessTokenExpireDuration,
+		RefreshExpiresIn: RefreshTokenExpireDuration,
+	}, nil
+}
+
+func (s *jwtService) VerifyToken(tokenString string) (uint, error) {
+	claims := &Claims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(s.secretKey), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
+	if err != nil {
+		return 0, err
+	}
+	if !token.Valid {
+		return 0, errors.New("invalid token")
+	}
+
+	session, err := s.sessionRepo.GetByID(claims.SessionID)
+	if err != nil {
+		if errors.Is(err, repoInterfaces.ErrSessionNotFound) {
+			return 0, ErrSessionRevoked
+		}
+		return 0, fmt.Errorf("cant get session: %w", err)
+	}
+
+	if session.UserID != claims.UserID || !session.IsActive(time.Now()) {
+		return 0, ErrSessionRevoked
+	}
+
+	return claims.UserID, nil
+}
+
+func (s *jwtService) RevokeSession(refreshToken string) error {
+	session, err := s.sessionRepo.GetByRefreshTokenHash(hashRefreshToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, repoInterfaces.ErrSessionNotFound) {
+			return ErrInvalidRefreshToken
+		}
+		return fmt.Errorf("cant get session: %w", err)
+	}
+
+	err = s.sessionRepo.Revoke(session.ID)
+	if err != nil {
+		return fmt.Errorf("cant revoke session: %w", err)
+	}
+
+	return nil
+}
+
+func (s *jwtService) RevokeAllSessions(userID uint) error {
+	err := s.sessionRepo.RevokeAllByUserID(userID)
+	if err != nil {
+		return fmt.Errorf("cant revoke sessions: %w", err)
+	}
+	return nil
+}
+
+func (s *jwtService) generateAccessToken(userID, sessionID uint) (string, error) {
 	claims := &Claims{
-		UserID: userID,
+		UserID:    userID,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Second * AccessTokenExpireDuration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -41,15 +209,18 @@ func (s *jwtService) GenerateToken(userID uint) (string, error) {
 	return token.SignedString([]byte(s.secretKey))
 }
 
-func (s *jwtService) VerifyToken(tokenString string) (uint, error) {
-	claims := &Claims{}
+// newRefreshToken returns an opaque token for the client and the hash that is stored in the database
+func newRefreshToken() (string, string, error) {
+	buf := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("cant generate refresh token: %w", err)
+	}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.secretKey), nil
-	})
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashRefreshToken(token), nil
+}
 
-	if err != nil || !token.Valid {
-		return 0, err
-	}
-	return claims.UserID, nil
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"simpleMessenger/internal/model"
 	"simpleMessenger/internal/repository/interfaces"
+	"time"
 )
 
 var (
@@ -80,3 +81,55 @@ func (s *UserService) UpdateUser(user *model.User) error {
 
 	return nil
 }
+
+// RecordLastSeen stores the moment a user went offline and returns it as others may see it
+func (s *UserService) RecordLastSeen(userID uint) (*time.Time, error) {
+	now := time.Now()
+
+	err := s.userRepo.UpdateLastSeen(userID, now)
+	if err != nil {
+		if errors.Is(err, interfaces.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.HideLastSeen {
+		return nil, nil
+	}
+
+	return &now, nil
+}
+
+// GetPresence tells viewerID whether userID is online and, unless it is hidden, when they were last seen
+func (s *UserService) GetPresence(userID, viewerID uint, online bool) (*model.Presence, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	presence := &model.Presence{UserID: user.ID, Online: online}
+	if !online && (!user.HideLastSeen || userID == viewerID) {
+		presence.LastSeenAt = user.LastSeenAt
+	}
+
+	return presence, nil
+}
+
+func (s *UserService) SetHideLastSeen(userID uint, hide bool) error {
+	err := s.userRepo.SetHideLastSeen(userID, hide)
+
+	if err != nil {
+		if errors.Is(err, interfaces.ErrUserNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	ErrBlobNotFound   = errors.New("blob not found")
+	ErrInvalidBlobKey = errors.New("invalid blob key")
+)
+
+// BlobStore keeps opaque file contents by key. Keys are chosen by the caller and may
+// only contain lowercase letters, digits and dashes.
+type BlobStore interface {
+	// Put stores everything read from r and returns the number of bytes written
+	Put(key string, r io.Reader) (int64, error)
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+func validateKey(key string) error {
+	if len(key) < 3 {
+		return ErrInvalidBlobKey
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return ErrInvalidBlobKey
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"simpleMessenger/internal/service"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead leaves room for the boundaries and headers around the uploaded file
+const multipartOverhead = 1 << 20
+
+type AttachmentHandler struct {
+	attachmentService *service.AttachmentService
+}
+
+func NewAttachmentHandler(attachmentService *service.AttachmentService) *AttachmentHandler {
+	return &AttachmentHandler{attachmentService: attachmentService}
+}
+
+func (h *AttachmentHandler) UploadAttachment(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	chatIDStr := c.Param("chatId")
+	chatID, err := strconv.ParseUint(chatIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse chat id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chatID"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, service.MaxAttachmentSize+multipartOverhead)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": service.ErrAttachmentTooLarge.Error()})
+			return
+		}
+		log.Printf("failed to read uploaded file: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "multipart field file is required"})
+		return
+	}
+
+	if fileHeader.Size > service.MaxAttachmentSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": service.ErrAttachmentTooLarge.Error()})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Printf("failed to open uploaded file: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload attachment"})
+		return
+	}
+	defer file.Close()
+
+	attachment, err := h.attachmentService.Upload(&service.UploadAttachmentRequest{
+		ChatID:   uint(chatID),
+		UserID:   userID,
+		FileName: fileHeader.Filename,
+		Content:  file,
+	})
+
+	if err != nil {
+		log.Printf("failed to upload attachment to chat %d: %v", chatID, err)
+		c.JSON(attachmentErrorStatus(err), gin.H{"error": "failed to upload attachment"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"attachment": attachment})
+}
+
+func (h *AttachmentHandler) DownloadAttachment(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	attachmentIDStr := c.Param("attachmentId")
+	attachmentID, err := strconv.ParseUint(attachmentIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse attachment id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid attachmentID"})
+		return
+	}
+
+	attachment, content, err := h.attachmentService.Open(uint(attachmentID), userID)
+
+	if err != nil {
+		log.Printf("failed to open attachment %d: %v", attachmentID, err)
+		c.JSON(attachmentErrorStatus(err), gin.H{"error": "failed to get attachment"})
+		return
+	}
+	defer content.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(attachment.MimeType, "image/") {
+		disposition = "inline"
+	}
+
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.MimeType, content, map[string]string{
+		"Content-Disposition":    fmt.Sprintf("%s; filename*=UTF-8''%s", disposition, url.PathEscape(attachment.FileName)),
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, max-age=86400",
+	})
+}
+
+func (h *AttachmentHandler) GetAttachmentThumbnail(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	attachmentIDStr := c.Param("attachmentId")
+	attachmentID, err := strconv.ParseUint(attachmentIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse attachment id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid attachmentID"})
+		return
+	}
+
+	attachment, content, err := h.attachmentService.OpenThumbnail(uint(attachmentID), userID)
+
+	if err != nil {
+		log.Printf("failed to open thumbnail of attachment %d: %v", attachmentID, err)
+		c.JSON(attachmentErrorStatus(err), gin.H{"error": "failed to get thumbnail"})
+		return
+	}
+	defer content.Close()
+
+	// the thumbnail size is not stored, -1 leaves the length to chunked encoding
+	c.DataFromReader(http.StatusOK, -1, attachment.ThumbnailMimeType, content, map[string]string{
+		"Content-Disposition":    "inline",
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, max-age=86400",
+	})
+}
+
+func attachmentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrNoThumbnail):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrAttachmentTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrAttachmentTypeNotAllowed):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, service.ErrUploadQuotaExceeded):
+		return http.StatusTooManyRequests
+	default:
+		return messageErrorStatus(err)
+	}
+}
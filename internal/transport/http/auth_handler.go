@@ -2,6 +2,7 @@ package http
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"log"
@@ -12,10 +13,16 @@ import (
 
 type LoginRequest struct {
 	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 type RegisterRequest struct {
 	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
 }
 
 type AuthHandler struct {
@@ -35,14 +42,27 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	err := h.authService.Register(&model.User{Login: req.Username})
+	if req.Username == "" {
+		log.Printf("failed to unmarshal register request: empty username")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username is empty"})
+		return
+	}
+
+	err := h.authService.Register(&model.User{Login: req.Username}, req.Password)
 	if err != nil {
 		log.Printf("failed to register user: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to register user"})
+		switch {
+		case errors.Is(err, service.ErrPasswordTooShort), errors.Is(err, service.ErrPasswordTooLong):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrUserAlreadyExists):
+			c.JSON(http.StatusConflict, gin.H{"error": "user already exists"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to register user"})
+		}
 		return
 	}
 
-	response, err := h.authService.Login(req.Username)
+	response, err := h.authService.Login(req.Username, req.Password)
 	if err != nil {
 		log.Printf("failed to login: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to login"})
@@ -67,15 +87,93 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	response, err := h.authService.Login(req.Username)
+	if req.Password == "" {
+		log.Printf("failed to unmarshal login request: empty password")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password is empty"})
+		return
+	}
+
+	response, err := h.authService.Login(req.Username, req.Password)
 	if err != nil {
 		log.Printf("failed to login: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to login"})
+		switch {
+		case errors.Is(err, service.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		case errors.Is(err, service.ErrInvalidPassword), errors.Is(err, service.ErrPasswordNotDefined):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to login"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// POST /api/auth/refresh
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	req := &RefreshRequest{}
+
+	if err := c.ShouldBindJSON(req); err != nil || req.RefreshToken == "" {
+		log.Printf("failed to unmarshal refresh request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad refresh request"})
+		return
+	}
+
+	response, err := h.authService.Refresh(req.RefreshToken)
+	if err != nil {
+		log.Printf("failed to refresh tokens: %v", err)
+		if errors.Is(err, service.ErrInvalidRefreshToken) || errors.Is(err, service.ErrSessionRevoked) || errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to refresh tokens"})
 		return
 	}
 	c.JSON(http.StatusOK, response)
 }
 
+// POST /api/auth/logout
+func (h *AuthHandler) Logout(c *gin.Context) {
+	req := &RefreshRequest{}
+
+	if err := c.ShouldBindJSON(req); err != nil || req.RefreshToken == "" {
+		log.Printf("failed to unmarshal logout request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad logout request"})
+		return
+	}
+
+	err := h.authService.Logout(req.RefreshToken)
+	if err != nil {
+		log.Printf("failed to logout: %v", err)
+		if errors.Is(err, service.ErrInvalidRefreshToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to logout"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// POST /api/auth/logout-all
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	err = h.authService.LogoutAll(userID)
+	if err != nil {
+		log.Printf("failed to logout user %d from all devices: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to logout from all devices"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out from all devices"})
+}
+
 func AuthMiddleware(tokenService service.TokenService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := c.GetHeader("Authorization")
@@ -1,19 +1,24 @@
 package http
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"log"
 	"net/http"
+	"simpleMessenger/internal/model"
+	repoInterfaces "simpleMessenger/internal/repository/interfaces"
 	"simpleMessenger/internal/service"
+	"simpleMessenger/internal/transport/websocket"
 	"strconv"
 )
 
 type ChatHandler struct {
 	chatService *service.ChatService
+	wsHub       *websocket.Hub
 }
 
-func NewChatHandler(chatService *service.ChatService) *ChatHandler {
-	return &ChatHandler{chatService: chatService}
+func NewChatHandler(chatService *service.ChatService, wsHub *websocket.Hub) *ChatHandler {
+	return &ChatHandler{chatService: chatService, wsHub: wsHub}
 }
 
 func (h *ChatHandler) CreateChat(c *gin.Context) {
@@ -75,6 +80,36 @@ func (h *ChatHandler) GetChats(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"chats": chats})
 }
 
+func (h *ChatHandler) GetChatSummaries(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	limitStr := c.DefaultQuery("limit", "20")
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit parameter"})
+		return
+	}
+
+	if limit > 100 {
+		limit = 100
+	}
+
+	page, err := h.chatService.GetChatSummaries(userID, limit, c.Query("cursor"))
+	if err != nil {
+		log.Printf("failed to get chat summaries for user %d: %v", userID, err)
+		c.JSON(chatErrorStatus(err), gin.H{"error": "failed to retrieve chats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
 func (h *ChatHandler) DeleteChat(c *gin.Context) {
 	userID, err := GetUserIdFromContext(c)
 
@@ -93,13 +128,219 @@ func (h *ChatHandler) DeleteChat(c *gin.Context) {
 		return
 	}
 
-	err = h.chatService.DeleteChat(uint(chatID), userID)
+	participants, err := h.chatService.DeleteChat(uint(chatID), userID)
 
 	if err != nil {
 		log.Printf("failed to delete chat %d: %v", chatID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete chat"})
+		c.JSON(chatErrorStatus(err), gin.H{"error": "failed to delete chat"})
 		return
 	}
 
+	if err := h.wsHub.NotifyChatDeleted(uint(chatID), participants); err != nil {
+		log.Printf("failed to broadcast deletion of chat %d: %v", chatID, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Chat deleted successfully"})
 }
+
+func (h *ChatHandler) CreateGroupChat(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	var req struct {
+		Title     string `json:"title"`
+		MemberIDs []uint `json:"memberIds"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("failed to parse body: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to unmarshal group chat request"})
+		return
+	}
+
+	chat, err := h.chatService.CreateGroupChat(userID, req.Title, req.MemberIDs)
+	if err != nil {
+		log.Printf("failed to create group chat: %v", err)
+		c.JSON(chatErrorStatus(err), gin.H{"error": "failed to create group chat"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"chat": chat})
+}
+
+func (h *ChatHandler) AddMembers(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	chatIDStr := c.Param("chatId")
+	chatID, err := strconv.ParseUint(chatIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse chat id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chatID"})
+		return
+	}
+
+	var req struct {
+		UserIDs []uint `json:"userIds"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.UserIDs) == 0 {
+		log.Printf("failed to parse body: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to unmarshal user ids"})
+		return
+	}
+
+	err = h.chatService.AddMembers(uint(chatID), userID, req.UserIDs)
+	if err != nil {
+		log.Printf("failed to add members to chat %d: %v", chatID, err)
+		c.JSON(chatErrorStatus(err), gin.H{"error": "failed to add members"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Members added successfully"})
+}
+
+func (h *ChatHandler) RemoveMember(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	chatIDStr := c.Param("chatId")
+	chatID, err := strconv.ParseUint(chatIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse chat id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chatID"})
+		return
+	}
+
+	memberIDStr := c.Param("userId")
+	memberID, err := strconv.ParseUint(memberIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse user id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid userID"})
+		return
+	}
+
+	err = h.chatService.RemoveMember(uint(chatID), userID, uint(memberID))
+	if err != nil {
+		log.Printf("failed to remove member %d from chat %d: %v", memberID, chatID, err)
+		c.JSON(chatErrorStatus(err), gin.H{"error": "failed to remove member"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+func (h *ChatHandler) LeaveChat(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	chatIDStr := c.Param("chatId")
+	chatID, err := strconv.ParseUint(chatIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse chat id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chatID"})
+		return
+	}
+
+	err = h.chatService.LeaveChat(uint(chatID), userID)
+	if err != nil {
+		log.Printf("failed to leave chat %d: %v", chatID, err)
+		c.JSON(chatErrorStatus(err), gin.H{"error": "failed to leave chat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Left chat successfully"})
+}
+
+func (h *ChatHandler) SetMemberRole(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	chatIDStr := c.Param("chatId")
+	chatID, err := strconv.ParseUint(chatIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse chat id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chatID"})
+		return
+	}
+
+	memberIDStr := c.Param("userId")
+	memberID, err := strconv.ParseUint(memberIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse user id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid userID"})
+		return
+	}
+
+	var req struct {
+		Role model.ChatRole `json:"role"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("failed to parse body: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to unmarshal role"})
+		return
+	}
+
+	err = h.chatService.SetMemberRole(uint(chatID), userID, uint(memberID), req.Role)
+	if err != nil {
+		log.Printf("failed to set role of member %d in chat %d: %v", memberID, chatID, err)
+		c.JSON(chatErrorStatus(err), gin.H{"error": "failed to set member role"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member role updated successfully"})
+}
+
+func chatErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrEmptyChatTitle),
+		errors.Is(err, service.ErrChatTitleTooLong),
+		errors.Is(err, service.ErrTooManyMembers),
+		errors.Is(err, service.ErrNotGroupChat),
+		errors.Is(err, service.ErrInvalidChatRole),
+		errors.Is(err, service.ErrInvalidCursor):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrUserNotInChat),
+		errors.Is(err, service.ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, repoInterfaces.ErrChatNotFound),
+		errors.Is(err, repoInterfaces.ErrUserNotFound),
+		errors.Is(err, repoInterfaces.ErrMessageNotFound),
+		errors.Is(err, repoInterfaces.ErrAttachmentNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -1,10 +1,12 @@
 package http
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"log"
 	"net/http"
 	"simpleMessenger/internal/service"
+	"simpleMessenger/internal/transport/websocket"
 	"strconv"
 )
 
@@ -15,10 +17,11 @@ type GetMessagesRequest struct {
 
 type MessageHandler struct {
 	messageService *service.MessageService
+	wsHub          *websocket.Hub
 }
 
-func NewMessageHandler(messageService *service.MessageService) *MessageHandler {
-	return &MessageHandler{messageService: messageService}
+func NewMessageHandler(messageService *service.MessageService, wsHub *websocket.Hub) *MessageHandler {
+	return &MessageHandler{messageService: messageService, wsHub: wsHub}
 }
 
 func (h *MessageHandler) SendMessage(c *gin.Context) {
@@ -40,7 +43,12 @@ func (h *MessageHandler) SendMessage(c *gin.Context) {
 	}
 
 	var req struct {
-		Text string `json:"text"`
+		Text             string `json:"text"`
+		ClientMsgID      string `json:"clientMsgId"`
+		AttachmentIDs    []uint `json:"attachmentIds"`
+		ReplyToMessageID uint   `json:"replyToMessageId"`
+		ThreadRootID     uint   `json:"threadRootId"`
+		AlsoSendToChat   bool   `json:"alsoSendToChat"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -49,21 +57,39 @@ func (h *MessageHandler) SendMessage(c *gin.Context) {
 		return
 	}
 
+	if req.ClientMsgID == "" {
+		req.ClientMsgID = c.GetHeader("Idempotency-Key")
+	}
+
 	sendMessageRequest := &service.SendMessageRequest{
-		Text:   req.Text,
-		UserID: userID,
-		ChatID: uint(chatID),
+		Text:             req.Text,
+		UserID:           userID,
+		ChatID:           uint(chatID),
+		ClientMsgID:      req.ClientMsgID,
+		AttachmentIDs:    req.AttachmentIDs,
+		ReplyToMessageID: req.ReplyToMessageID,
+		ThreadRootID:     req.ThreadRootID,
+		AlsoSendToChat:   req.AlsoSendToChat,
 	}
 
-	_, err = h.messageService.SendMessage(sendMessageRequest)
+	message, created, err := h.messageService.SendMessage(sendMessageRequest)
 
 	if err != nil {
 		log.Printf("failed to send message: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send message"})
+		c.JSON(messageErrorStatus(err), gin.H{"error": "failed to send message"})
+		return
+	}
+
+	if !created {
+		c.JSON(http.StatusOK, gin.H{"message": message})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "Message sent"})
+	if err := h.wsHub.NotifyMessageCreated(message); err != nil {
+		log.Printf("failed to broadcast message %d: %v", message.ID, err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": message})
 }
 
 func (h *MessageHandler) GetMessages(c *gin.Context) {
@@ -96,15 +122,80 @@ func (h *MessageHandler) GetMessages(c *gin.Context) {
 		limit = 100
 	}
 
-	messages, err := h.messageService.GetMessages(uint(chatID), limit, userID)
+	getMessagesRequest := &service.GetMessagesRequest{
+		ChatID: uint(chatID),
+		UserID: userID,
+		Limit:  limit,
+		Before: c.Query("before"),
+		After:  c.Query("after"),
+	}
+
+	if aroundStr := c.Query("around"); aroundStr != "" {
+		aroundID, err := strconv.ParseUint(aroundStr, 10, 64)
+		if err != nil || aroundID == 0 {
+			log.Printf("failed to parse around param: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid around parameter"})
+			return
+		}
+		getMessagesRequest.AroundMessageID = uint(aroundID)
+	}
+
+	page, err := h.messageService.GetMessages(getMessagesRequest)
 
 	if err != nil {
 		log.Printf("failed to get messages for chat %d: %v", chatID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve messages"})
+		c.JSON(messageErrorStatus(err), gin.H{"error": "failed to retrieve messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *MessageHandler) GetThread(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	messageIDStr := c.Param("messageId")
+	messageID, err := strconv.ParseUint(messageIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse message id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid messageID"})
+		return
+	}
+
+	limitStr := c.DefaultQuery("limit", "20")
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 {
+		log.Printf("failed to parse limit param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit parameter"})
+		return
+	}
+
+	if limit > 100 {
+		limit = 100
+	}
+
+	thread, err := h.messageService.GetThread(&service.GetThreadRequest{
+		RootMessageID: uint(messageID),
+		UserID:        userID,
+		Limit:         limit,
+		Before:        c.Query("before"),
+		After:         c.Query("after"),
+	})
+
+	if err != nil {
+		log.Printf("failed to get thread of message %d: %v", messageID, err)
+		c.JSON(messageErrorStatus(err), gin.H{"error": "failed to retrieve thread"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"messages": messages})
+	c.JSON(http.StatusOK, thread)
 }
 
 func (h *MessageHandler) DeleteMessage(c *gin.Context) {
@@ -125,13 +216,294 @@ func (h *MessageHandler) DeleteMessage(c *gin.Context) {
 		return
 	}
 
-	err = h.messageService.DeleteMessage(uint(messageID), userID)
+	message, err := h.messageService.DeleteMessage(uint(messageID), userID)
 
 	if err != nil {
 		log.Printf("failed to delete message: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete message"})
+		c.JSON(messageErrorStatus(err), gin.H{"error": "failed to delete message"})
 		return
 	}
 
+	if err := h.wsHub.NotifyMessageDeleted(message, userID); err != nil {
+		log.Printf("failed to broadcast deletion of message %d: %v", message.ID, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Message deleted successfully"})
 }
+
+func (h *MessageHandler) EditMessage(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	messageIDStr := c.Param("messageId")
+	messageID, err := strconv.ParseUint(messageIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse message id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid messageID"})
+		return
+	}
+
+	var req struct {
+		Text string `json:"text"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("failed to unmarshal json with text")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to unmarshal text message"})
+		return
+	}
+
+	editMessageRequest := &service.EditMessageRequest{
+		Text:      req.Text,
+		UserID:    userID,
+		MessageID: uint(messageID),
+	}
+
+	message, err := h.messageService.EditMessage(editMessageRequest)
+
+	if err != nil {
+		log.Printf("failed to edit message: %v", err)
+		c.JSON(messageErrorStatus(err), gin.H{"error": "failed to edit message"})
+		return
+	}
+
+	if err := h.wsHub.NotifyMessageEdited(message); err != nil {
+		log.Printf("failed to broadcast edited message %d: %v", message.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+func (h *MessageHandler) GetMessageHistory(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	messageIDStr := c.Param("messageId")
+	messageID, err := strconv.ParseUint(messageIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse message id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid messageID"})
+		return
+	}
+
+	revisions, err := h.messageService.GetMessageHistory(uint(messageID), userID)
+
+	if err != nil {
+		log.Printf("failed to get history of message %d: %v", messageID, err)
+		c.JSON(messageErrorStatus(err), gin.H{"error": "failed to retrieve message history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revisions": revisions})
+}
+
+func (h *MessageHandler) MarkRead(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	chatIDStr := c.Param("chatId")
+	chatID, err := strconv.ParseUint(chatIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse chat id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chatID"})
+		return
+	}
+
+	var req struct {
+		MessageID uint `json:"messageId"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || req.MessageID == 0 {
+		log.Printf("failed to parse body: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to unmarshal message id"})
+		return
+	}
+
+	receipt, delivery, err := h.messageService.MarkRead(uint(chatID), userID, req.MessageID)
+
+	if err != nil {
+		log.Printf("failed to mark chat %d as read: %v", chatID, err)
+		c.JSON(messageErrorStatus(err), gin.H{"error": "failed to mark messages as read"})
+		return
+	}
+
+	if delivery != nil {
+		if err := h.wsHub.NotifyDelivered(delivery); err != nil {
+			log.Printf("failed to broadcast delivery receipt in chat %d: %v", chatID, err)
+		}
+	}
+
+	if receipt != nil {
+		if err := h.wsHub.NotifyRead(receipt); err != nil {
+			log.Printf("failed to broadcast read receipt in chat %d: %v", chatID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Messages marked as read"})
+}
+
+func (h *MessageHandler) GetMessageReaders(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	messageIDStr := c.Param("messageId")
+	messageID, err := strconv.ParseUint(messageIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse message id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid messageID"})
+		return
+	}
+
+	readers, err := h.messageService.GetMessageReaders(uint(messageID), userID)
+
+	if err != nil {
+		log.Printf("failed to get readers of message %d: %v", messageID, err)
+		c.JSON(messageErrorStatus(err), gin.H{"error": "failed to retrieve message readers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"readers": readers})
+}
+
+func (h *MessageHandler) GetMessageStatus(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	messageIDStr := c.Param("messageId")
+	messageID, err := strconv.ParseUint(messageIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse message id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid messageID"})
+		return
+	}
+
+	status, err := h.messageService.GetMessageStatus(uint(messageID), userID)
+
+	if err != nil {
+		log.Printf("failed to get status of message %d: %v", messageID, err)
+		c.JSON(messageErrorStatus(err), gin.H{"error": "failed to retrieve message status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *MessageHandler) AddReaction(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	messageIDStr := c.Param("messageId")
+	messageID, err := strconv.ParseUint(messageIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse message id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid messageID"})
+		return
+	}
+
+	update, err := h.messageService.AddReaction(uint(messageID), userID, c.Param("emoji"))
+
+	if err != nil {
+		log.Printf("failed to add reaction to message %d: %v", messageID, err)
+		c.JSON(messageErrorStatus(err), gin.H{"error": "failed to add reaction"})
+		return
+	}
+
+	if update != nil {
+		if err := h.wsHub.NotifyReaction(update); err != nil {
+			log.Printf("failed to broadcast reaction on message %d: %v", messageID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reaction added"})
+}
+
+func (h *MessageHandler) RemoveReaction(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	messageIDStr := c.Param("messageId")
+	messageID, err := strconv.ParseUint(messageIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse message id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid messageID"})
+		return
+	}
+
+	update, err := h.messageService.RemoveReaction(uint(messageID), userID, c.Param("emoji"))
+
+	if err != nil {
+		log.Printf("failed to remove reaction from message %d: %v", messageID, err)
+		c.JSON(messageErrorStatus(err), gin.H{"error": "failed to remove reaction"})
+		return
+	}
+
+	if update != nil {
+		if err := h.wsHub.NotifyReaction(update); err != nil {
+			log.Printf("failed to broadcast reaction on message %d: %v", messageID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reaction removed"})
+}
+
+func messageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrEmptyMessageText),
+		errors.Is(err, service.ErrClientMsgIDTooLong),
+		errors.Is(err, service.ErrInvalidCursor),
+		errors.Is(err, service.ErrTooManyAttachments),
+		errors.Is(err, service.ErrInvalidAttachment),
+		errors.Is(err, service.ErrInvalidEmoji),
+		errors.Is(err, service.ErrTooManyReactions),
+		errors.Is(err, service.ErrInvalidReplyTarget),
+		errors.Is(err, service.ErrInvalidThreadRoot):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrNotMessageAuthor),
+		errors.Is(err, service.ErrEditWindowExpired):
+		return http.StatusForbidden
+	default:
+		return chatErrorStatus(err)
+	}
+}
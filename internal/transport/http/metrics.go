@@ -0,0 +1,17 @@
+package http
+
+import (
+	"expvar"
+	"net/http"
+	"simpleMessenger/internal/transport/websocket"
+)
+
+// ServeMetrics exposes expvar, hub stats included, on a listener of its own so it is not
+// reachable through the public API. It blocks like http.ListenAndServe.
+func ServeMetrics(addr string, wsHub *websocket.Hub) error {
+	expvar.Publish("websocket", expvar.Func(func() any {
+		return wsHub.Stats()
+	}))
+
+	return http.ListenAndServe(addr, expvar.Handler())
+}
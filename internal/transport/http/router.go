@@ -20,7 +20,7 @@ func NewRouter() *Router {
 	engine.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", "Idempotency-Key"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
@@ -34,6 +34,7 @@ func (r *Router) SetupRouter(
 	userHandler *UserHandler,
 	chatHandler *ChatHandler,
 	messageHandler *MessageHandler,
+	attachmentHandler *AttachmentHandler,
 	tokenService service.TokenService,
 	wsHub *websocket.Hub,
 ) {
@@ -41,22 +42,47 @@ func (r *Router) SetupRouter(
 	{
 		public.POST("/auth/register", authHandler.Register)
 		public.POST("/auth/login", authHandler.Login)
+		public.POST("/auth/refresh", authHandler.Refresh)
+		public.POST("/auth/logout", authHandler.Logout)
 	}
 
 	protected := r.engine.Group("/api")
 	protected.Use(AuthMiddleware(tokenService))
 	{
+		protected.POST("/auth/logout-all", authHandler.LogoutAll)
+
 		protected.GET("/users", userHandler.GetUsers) // query: id, login, search, limit
+		protected.GET("/users/:userId/presence", userHandler.GetPresence)
+		protected.PATCH("/users/me/privacy", userHandler.UpdatePrivacy)
+		protected.GET("/users/me/devices", userHandler.GetDevices)
+		protected.DELETE("/users/me/devices/:deviceId", userHandler.DisconnectDevice)
 
-		protected.GET("/chats", chatHandler.GetChats) // query: limit
+		protected.GET("/chats", chatHandler.GetChats)                   // query: limit
+		protected.GET("/chats/summaries", chatHandler.GetChatSummaries) // query: limit, cursor
 		protected.POST("/chats", chatHandler.CreateChat)
 		protected.DELETE("/chats/:chatId", chatHandler.DeleteChat)
+		protected.POST("/chats/group", chatHandler.CreateGroupChat)
+		protected.POST("/chats/:chatId/members", chatHandler.AddMembers)
+		protected.DELETE("/chats/:chatId/members/:userId", chatHandler.RemoveMember)
+		protected.PATCH("/chats/:chatId/members/:userId", chatHandler.SetMemberRole)
+		protected.POST("/chats/:chatId/leave", chatHandler.LeaveChat)
 
-		protected.GET("/chats/:chatId/messages", messageHandler.GetMessages) // query: limit
+		protected.GET("/chats/:chatId/messages", messageHandler.GetMessages) // query: limit, before, after, around
 
 		protected.POST("/chats/:chatId/messages", messageHandler.SendMessage)
+		protected.POST("/chats/:chatId/read", messageHandler.MarkRead)
+		protected.POST("/chats/:chatId/attachments", attachmentHandler.UploadAttachment) // multipart field: file
+		protected.GET("/attachments/:attachmentId", attachmentHandler.DownloadAttachment)
+		protected.GET("/attachments/:attachmentId/thumbnail", attachmentHandler.GetAttachmentThumbnail)
 
+		protected.PATCH("/messages/:messageId", messageHandler.EditMessage)
+		protected.GET("/messages/:messageId/history", messageHandler.GetMessageHistory)
+		protected.GET("/messages/:messageId/readers", messageHandler.GetMessageReaders)
+		protected.GET("/messages/:messageId/status", messageHandler.GetMessageStatus)
+		protected.GET("/messages/:messageId/thread", messageHandler.GetThread) // query: limit, before, after
 		protected.DELETE("/messages/:messageId", messageHandler.DeleteMessage)
+		protected.PUT("/messages/:messageId/reactions/:emoji", messageHandler.AddReaction)
+		protected.DELETE("/messages/:messageId/reactions/:emoji", messageHandler.RemoveReaction)
 
 		protected.GET("/ws", func(c *gin.Context) {
 			websocket.ServeWs(wsHub, c.Writer, c.Request)
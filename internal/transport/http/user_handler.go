@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"simpleMessenger/internal/service"
+	"simpleMessenger/internal/transport/websocket"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -12,10 +13,16 @@ import (
 
 type UserHandler struct {
 	userService *service.UserService
+	chatService *service.ChatService
+	wsHub       *websocket.Hub
 }
 
-func NewUserHandler(userService *service.UserService) *UserHandler {
-	return &UserHandler{userService: userService}
+func NewUserHandler(userService *service.UserService, chatService *service.ChatService, wsHub *websocket.Hub) *UserHandler {
+	return &UserHandler{userService: userService, chatService: chatService, wsHub: wsHub}
+}
+
+type UpdatePrivacyRequest struct {
+	HideLastSeen *bool `json:"hideLastSeen" binding:"required"`
 }
 
 func (h *UserHandler) GetUsers(c *gin.Context) {
@@ -95,3 +102,113 @@ func (h *UserHandler) GetUsers(c *gin.Context) {
 
 	c.JSON(http.StatusBadRequest, gin.H{"error": "id, login or search query parameter is required"})
 }
+
+func (h *UserHandler) GetPresence(c *gin.Context) {
+	viewerID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	userIDStr := c.Param("userId")
+	userID, err := strconv.ParseUint(userIDStr, 10, 64)
+
+	if err != nil {
+		log.Printf("failed to parse user id param: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid userID"})
+		return
+	}
+
+	// presence is only shown to people sharing a chat, others can't tell the user exists
+	shared, err := h.chatService.SharesChat(viewerID, uint(userID))
+
+	if err != nil {
+		log.Printf("failed to check shared chat with user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if !shared {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	online := h.wsHub.IsOnline(uint(userID))
+	presence, err := h.userService.GetPresence(uint(userID), viewerID, online)
+
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		log.Printf("failed to get presence of user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, presence)
+}
+
+func (h *UserHandler) UpdatePrivacy(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	var req UpdatePrivacyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("failed to bind privacy request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	err = h.userService.SetHideLastSeen(userID, *req.HideLastSeen)
+
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		log.Printf("failed to update privacy of user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"hideLastSeen": *req.HideLastSeen})
+}
+
+func (h *UserHandler) GetDevices(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"devices": h.wsHub.Devices(userID)})
+}
+
+func (h *UserHandler) DisconnectDevice(c *gin.Context) {
+	userID, err := GetUserIdFromContext(c)
+
+	if err != nil {
+		log.Printf("failed to get userID from context: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get a userID"})
+		return
+	}
+
+	deviceID := c.Param("deviceId")
+
+	if !h.wsHub.DisconnectDevice(userID, deviceID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Device disconnected successfully"})
+}
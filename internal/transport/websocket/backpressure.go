@@ -0,0 +1,96 @@
+package websocket
+
+import "fmt"
+
+// SlowConsumerPolicy decides what happens to a frame for a connection whose send buffer is full
+type SlowConsumerPolicy string
+
+const (
+	// PolicyDisconnect closes the connection with CloseSlowConsumer, the client resyncs on reconnect
+	PolicyDisconnect SlowConsumerPolicy = "disconnect"
+	// PolicyDropOldest makes room by discarding the oldest queued frames
+	PolicyDropOldest SlowConsumerPolicy = "drop-oldest"
+	// PolicyCoalesce stops queueing and replaces everything missed with a single resync event
+	PolicyCoalesce SlowConsumerPolicy = "coalesce"
+)
+
+const (
+	sendBufferSize = 256
+	// maxWriteBatch caps how many queued frames writePump joins into one websocket message
+	maxWriteBatch = 64
+	// dropOldestAttempts bounds how often a frame competes with other senders for a freed slot
+	dropOldestAttempts = 3
+)
+
+var newline = []byte{'\n'}
+
+// Application close codes, sent with a human readable reason in the close frame
+const (
+	CloseSlowConsumer       = 4008
+	CloseDeviceDisconnected = 4001
+)
+
+func ParseSlowConsumerPolicy(value string) (SlowConsumerPolicy, error) {
+	switch policy := SlowConsumerPolicy(value); policy {
+	case PolicyDisconnect, PolicyDropOldest, PolicyCoalesce:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown slow consumer policy %q", value)
+	}
+}
+
+// trySend queues a frame without blocking. It reports false if the connection has to be
+// dropped because it can't keep up. Frames for a connection that is already gone are
+// silently discarded.
+func (c *Client) trySend(message []byte) bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+	}
+
+	// once frames were lost nothing else is queued until the client was told to resync
+	if c.hub.slowConsumerPolicy == PolicyCoalesce && c.overflowed.Load() {
+		c.drop()
+		return true
+	}
+
+	select {
+	case c.send <- message:
+		return true
+	default:
+	}
+
+	switch c.hub.slowConsumerPolicy {
+	case PolicyDropOldest:
+		c.overflowed.Store(true)
+		for attempt := 0; attempt < dropOldestAttempts; attempt++ {
+			select {
+			case <-c.send:
+				c.drop()
+			default:
+			}
+
+			select {
+			case c.send <- message:
+				return true
+			default:
+			}
+		}
+		c.drop()
+		return true
+	case PolicyCoalesce:
+		c.overflowed.Store(true)
+		c.drop()
+		return true
+	default:
+		c.drop()
+		return false
+	}
+}
+
+// drop counts a lost frame for the connection and for the metrics of the whole hub
+func (c *Client) drop() {
+	c.dropped.Add(1)
+	c.hub.droppedFrames.Add(1)
+}
@@ -0,0 +1,110 @@
+package websocket
+
+import (
+	"slices"
+	"testing"
+)
+
+func newTestClient(policy SlowConsumerPolicy, buffer int) *Client {
+	return &Client{
+		hub:  &Hub{slowConsumerPolicy: policy},
+		send: make(chan []byte, buffer),
+		done: make(chan struct{}),
+	}
+}
+
+// sendAll queues frames one by one and returns what trySend said about the last one
+func sendAll(client *Client, frames ...string) bool {
+	ok := true
+	for _, frame := range frames {
+		ok = client.trySend([]byte(frame))
+	}
+	return ok
+}
+
+func drain(client *Client) []string {
+	var frames []string
+	for len(client.send) > 0 {
+		frames = append(frames, string(<-client.send))
+	}
+	return frames
+}
+
+func checkDropped(t *testing.T, client *Client, want uint64) {
+	t.Helper()
+
+	if got := client.dropped.Load(); got != want {
+		t.Errorf("client dropped %d frames, want %d", got, want)
+	}
+	if got := client.hub.droppedFrames.Load(); got != want {
+		t.Errorf("hub counted %d dropped frames, want %d", got, want)
+	}
+}
+
+func TestTrySendDisconnectsWhenFull(t *testing.T) {
+	client := newTestClient(PolicyDisconnect, 2)
+
+	if !sendAll(client, "a", "b") {
+		t.Fatal("trySend() = false while there was room")
+	}
+	if sendAll(client, "c") {
+		t.Error("trySend() = true on a full buffer, want the client to be dropped")
+	}
+
+	if got := drain(client); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("queued %v, want [a b]", got)
+	}
+	checkDropped(t, client, 1)
+}
+
+func TestTrySendDropOldestKeepsNewestFrames(t *testing.T) {
+	client := newTestClient(PolicyDropOldest, 2)
+
+	if !sendAll(client, "a", "b", "c", "d") {
+		t.Fatal("trySend() = false, drop-oldest never disconnects")
+	}
+
+	if got := drain(client); !slices.Equal(got, []string{"c", "d"}) {
+		t.Errorf("queued %v, want [c d]", got)
+	}
+	checkDropped(t, client, 2)
+	if !client.overflowed.Load() {
+		t.Error("client is not marked for a resync")
+	}
+}
+
+func TestTrySendCoalesceStopsQueueingUntilResync(t *testing.T) {
+	client := newTestClient(PolicyCoalesce, 2)
+
+	// d arrives after room was made, it must still wait for the resync
+	sendAll(client, "a", "b", "c")
+	drain(client)
+	if !sendAll(client, "d") {
+		t.Fatal("trySend() = false, coalesce never disconnects")
+	}
+	if len(client.send) != 0 {
+		t.Errorf("%d frames queued after the overflow, want none", len(client.send))
+	}
+	checkDropped(t, client, 2)
+
+	// writePump clears the flag once it sent the resync event
+	client.overflowed.Store(false)
+	sendAll(client, "e")
+	if got := drain(client); !slices.Equal(got, []string{"e"}) {
+		t.Errorf("queued %v after the resync, want [e]", got)
+	}
+}
+
+func TestTrySendToRemovedClient(t *testing.T) {
+	for _, policy := range []SlowConsumerPolicy{PolicyDisconnect, PolicyDropOldest, PolicyCoalesce} {
+		client := newTestClient(policy, 1)
+		close(client.done)
+
+		if !sendAll(client, "a", "b") {
+			t.Errorf("%s: trySend() = false for a removed client, want true", policy)
+		}
+		if len(client.send) != 0 || client.dropped.Load() != 0 {
+			t.Errorf("%s: frames for a removed client were queued or counted", policy)
+		}
+	}
+}
@@ -1,19 +1,47 @@
 package websocket
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"log"
 	"simpleMessenger/internal/model"
 	"simpleMessenger/internal/service"
+	"sync/atomic"
 	"time"
 )
 
 type Client struct {
-	hub    *Hub
-	conn   *websocket.Conn
-	send   chan []byte
-	userID uint
+	hub  *Hub
+	conn *websocket.Conn
+	send chan []byte
+	// done is closed by the hub once the connection is removed, send itself stays open
+	done         chan struct{}
+	userID       uint
+	typingLimits *rateLimiter
+	// id tells apart the devices of one user
+	id          string
+	userAgent   string
+	connectedAt time.Time
+	// overflowed is set when frames were lost and the client has not been told to resync yet
+	overflowed atomic.Bool
+	dropped    atomic.Uint64
+	// closeCode and closeReason are set by the hub before done is closed
+	closeCode   int
+	closeReason string
+}
+
+// Device describes one open connection of a user
+type Device struct {
+	ID          string    `json:"id"`
+	UserAgent   string    `json:"userAgent"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	// QueueDepth is the number of frames waiting to be written to the connection
+	QueueDepth    int    `json:"queueDepth"`
+	QueueCapacity int    `json:"queueCapacity"`
+	DroppedFrames uint64 `json:"droppedFrames"`
 }
 
 const (
@@ -23,9 +51,28 @@ const (
 	maxMessageSize = 512 * 1024
 )
 
+func (c *Client) device() *Device {
+	return &Device{
+		ID:            c.id,
+		UserAgent:     c.userAgent,
+		ConnectedAt:   c.connectedAt,
+		QueueDepth:    len(c.send),
+		QueueCapacity: cap(c.send),
+		DroppedFrames: c.dropped.Load(),
+	}
+}
+
+func newDeviceID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("cant generate device id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.Unregister(c)
 		c.conn.Close()
 	}()
 
@@ -49,35 +96,293 @@ func (c *Client) readPump() {
 }
 
 func (c *Client) handleMessage(data []byte) {
-	message := &model.Message{}
-	if err := json.Unmarshal(data, &message); err != nil {
+	request := &Request{}
+	if err := json.Unmarshal(data, request); err != nil {
 		log.Println("invalid message:", err)
+		c.reply(newErrorEvent("", ErrCodeBadRequest, "frame is not a valid request envelope"))
 		return
 	}
 
-	message.UserID = c.userID
+	if request.Version != ProtocolVersion {
+		c.reply(newErrorEvent(request.ID, ErrCodeUnsupportedVersion, fmt.Sprintf("protocol version %d is required", ProtocolVersion)))
+		return
+	}
 
-	req := &service.SendMessageRequest{
-		Text:   message.Text,
-		UserID: message.UserID,
-		ChatID: message.ChatID,
+	if request.ID == "" {
+		c.reply(newErrorEvent("", ErrCodeBadRequest, "request id is required"))
+		return
+	}
+
+	var (
+		result interface{}
+		err    error
+	)
+
+	switch request.Type {
+	case RequestSendMessage:
+		result, err = c.handleSend(request)
+	case RequestEditMessage:
+		result, err = c.handleEdit(request)
+	case RequestMarkRead:
+		result, err = c.handleMarkRead(request)
+	case RequestMarkDelivered:
+		result, err = c.handleMarkDelivered(request)
+	case RequestTypingStart, RequestTypingStop:
+		result, err = c.handleTyping(request)
+	case RequestSync:
+		result, err = c.handleSync(request)
+	case RequestReact, RequestUnreact:
+		result, err = c.handleReaction(request)
+	default:
+		err = newRequestError(ErrCodeUnknownType, fmt.Sprintf("unknown request type %q", request.Type))
 	}
 
-	msgResp, err := c.hub.messageService.SendMessage(req)
 	if err != nil {
-		log.Printf("send message error: %v", err)
+		log.Printf("%s request %s of user %d failed: %v", request.Type, request.ID, c.userID, err)
+		reqErr := toRequestError(err)
+		c.reply(newErrorEvent(request.ID, reqErr.code, reqErr.message))
 		return
 	}
 
-	msgBytes, err := json.Marshal(msgResp)
+	c.reply(newAckEvent(request.ID, result))
+}
+
+func (c *Client) handleSend(request *Request) (interface{}, error) {
+	payload := &SendMessagePayload{}
+	if err := decodePayload(request, payload); err != nil {
+		return nil, err
+	}
+
+	req := &service.SendMessageRequest{
+		Text:             payload.Text,
+		UserID:           c.userID,
+		ChatID:           payload.ChatID,
+		ClientMsgID:      payload.ClientMsgID,
+		AttachmentIDs:    payload.AttachmentIDs,
+		ReplyToMessageID: payload.ReplyToMessageID,
+		ThreadRootID:     payload.ThreadRootID,
+		AlsoSendToChat:   payload.AlsoSendToChat,
+	}
+
+	msgResp, created, err := c.hub.messageService.SendMessage(req)
 	if err != nil {
-		log.Printf("failed to marshal message: %v", err)
-		return
+		return nil, err
+	}
+
+	if !created {
+		return msgResp, nil
+	}
+
+	if err := c.hub.NotifyMessageCreated(msgResp); err != nil {
+		log.Printf("failed to send to chat: %v", err)
+	}
+
+	return msgResp, nil
+}
+
+func (c *Client) handleEdit(request *Request) (interface{}, error) {
+	payload := &EditMessagePayload{}
+	if err := decodePayload(request, payload); err != nil {
+		return nil, err
 	}
 
-	if err := c.hub.SendToChat(message.ChatID, message.UserID, msgBytes); err != nil {
+	req := &service.EditMessageRequest{
+		Text:      payload.Text,
+		UserID:    c.userID,
+		MessageID: payload.MessageID,
+	}
+
+	msgResp, err := c.hub.messageService.EditMessage(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.hub.NotifyMessageEdited(msgResp); err != nil {
+		log.Printf("failed to send to chat: %v", err)
+	}
+
+	return msgResp, nil
+}
+
+func (c *Client) handleMarkRead(request *Request) (interface{}, error) {
+	payload := &MarkReadPayload{}
+	if err := decodePayload(request, payload); err != nil {
+		return nil, err
+	}
+
+	receipt, delivery, err := c.hub.messageService.MarkRead(payload.ChatID, c.userID, payload.MessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	// reading implies delivery, senders waiting for their ticks get both
+	if delivery != nil {
+		if err := c.hub.NotifyDelivered(delivery); err != nil {
+			log.Printf("failed to send to chat: %v", err)
+		}
+	}
+
+	if receipt == nil {
+		return nil, nil
+	}
+
+	if err := c.hub.NotifyRead(receipt); err != nil {
 		log.Printf("failed to send to chat: %v", err)
 	}
+
+	return receipt, nil
+}
+
+func (c *Client) handleMarkDelivered(request *Request) (interface{}, error) {
+	payload := &MarkReadPayload{}
+	if err := decodePayload(request, payload); err != nil {
+		return nil, err
+	}
+
+	receipt, err := c.hub.messageService.MarkDelivered(payload.ChatID, c.userID, payload.MessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if receipt == nil {
+		return nil, nil
+	}
+
+	if err := c.hub.NotifyDelivered(receipt); err != nil {
+		log.Printf("failed to send to chat: %v", err)
+	}
+
+	return receipt, nil
+}
+
+func (c *Client) handleReaction(request *Request) (interface{}, error) {
+	payload := &ReactionPayload{}
+	if err := decodePayload(request, payload); err != nil {
+		return nil, err
+	}
+
+	var (
+		update *model.ReactionUpdate
+		err    error
+	)
+	if request.Type == RequestReact {
+		update, err = c.hub.messageService.AddReaction(payload.MessageID, c.userID, payload.Emoji)
+	} else {
+		update, err = c.hub.messageService.RemoveReaction(payload.MessageID, c.userID, payload.Emoji)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if update == nil {
+		return nil, nil
+	}
+
+	if err := c.hub.NotifyReaction(update); err != nil {
+		log.Printf("failed to send to chat: %v", err)
+	}
+
+	return update, nil
+}
+
+func (c *Client) handleTyping(request *Request) (interface{}, error) {
+	if !c.typingLimits.Allow() {
+		return nil, newRequestError(ErrCodeRateLimited, "too many typing events")
+	}
+
+	payload := &TypingRequestPayload{}
+	if err := decodePayload(request, payload); err != nil {
+		return nil, err
+	}
+
+	if request.Type == RequestTypingStart {
+		return nil, c.hub.typing.Start(payload.ChatID, c.userID)
+	}
+	return nil, c.hub.typing.Stop(payload.ChatID, c.userID)
+}
+
+func (c *Client) handleSync(request *Request) (interface{}, error) {
+	payload := &SyncPayload{}
+	if err := decodePayload(request, payload); err != nil {
+		return nil, err
+	}
+
+	sync, err := c.hub.messageService.Sync(c.userID, payload.Chats)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SyncResultPayload{
+		Chats:          make([]*ChatSyncPayload, 0, len(sync.Chats)),
+		RemovedChatIDs: sync.RemovedChatIDs,
+		HasMore:        sync.HasMore,
+	}
+
+	for _, changes := range sync.Chats {
+		chat := &ChatSyncPayload{
+			ChatID:  changes.ChatID,
+			LastSeq: changes.LastSeq,
+			New:     changes.New,
+			Events:  make([]*Event, 0, len(changes.Messages)),
+		}
+
+		for _, message := range changes.Messages {
+			chat.Events = append(chat.Events, newReplayEvent(message, changes.Since))
+		}
+
+		result.Chats = append(result.Chats, chat)
+	}
+
+	return result, nil
+}
+
+// reply sends an event to this connection only
+func (c *Client) reply(event *Event) {
+	data, err := event.Marshal()
+	if err != nil {
+		log.Printf("failed to marshal reply: %v", err)
+		return
+	}
+
+	c.hub.SendToClient(c, data)
+}
+
+// writeBatch writes message together with whatever else is already queued as one
+// websocket message, one frame per line. JSON frames never contain a raw newline.
+func (c *Client) writeBatch(message []byte) error {
+	w, err := c.conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		return err
+	}
+	w.Write(message)
+
+	for i := 1; i < maxWriteBatch; i++ {
+		var next []byte
+		select {
+		case next = <-c.send:
+		default:
+			return w.Close()
+		}
+
+		w.Write(newline)
+		w.Write(next)
+	}
+
+	return w.Close()
+}
+
+// writeResyncIfOverflowed tells a client that lost frames to sync, once its queue is drained
+func (c *Client) writeResyncIfOverflowed() error {
+	if len(c.send) > 0 || !c.overflowed.CompareAndSwap(true, false) {
+		return nil
+	}
+
+	data, err := (&Event{Type: EventResyncRequired, Payload: &ResyncPayload{DroppedFrames: c.dropped.Load()}}).Marshal()
+	if err != nil {
+		return err
+	}
+
+	return c.conn.WriteMessage(websocket.TextMessage, data)
 }
 
 func (c *Client) writePump() {
@@ -88,13 +393,16 @@ func (c *Client) writePump() {
 	}()
 	for {
 		select {
-		case message, ok := <-c.send:
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeReason))
+			return
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.writeBatch(message); err != nil {
 				return
 			}
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			if err := c.writeResyncIfOverflowed(); err != nil {
 				return
 			}
 		case <-ticker.C:
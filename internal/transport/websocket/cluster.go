@@ -0,0 +1,276 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"simpleMessenger/internal/broker"
+	"sync"
+	"time"
+)
+
+const (
+	topicConnections = "connections"
+
+	heartbeatInterval = 10 * time.Second
+	// instanceTimeout is how long an instance may stay silent before its connections are
+	// considered gone, it has crashed or lost the broker
+	instanceTimeout = 3 * heartbeatInterval
+)
+
+type clusterEventType string
+
+const (
+	clusterConnected    clusterEventType = "connected"
+	clusterDisconnected clusterEventType = "disconnected"
+	clusterHeartbeat    clusterEventType = "heartbeat"
+	// clusterHello is sent by a starting instance, the others answer with a snapshot
+	clusterHello    clusterEventType = "hello"
+	clusterSnapshot clusterEventType = "snapshot"
+	// clusterDisconnectDevice asks the instance holding a connection to close it
+	clusterDisconnectDevice clusterEventType = "disconnect_device"
+)
+
+// clusterDevice is a connection as other instances know it, without queue metrics
+type clusterDevice struct {
+	UserID      uint      `json:"userId"`
+	ID          string    `json:"id"`
+	UserAgent   string    `json:"userAgent"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+type clusterEvent struct {
+	Type     clusterEventType `json:"type"`
+	Instance string           `json:"instance"`
+	Device   *clusterDevice   `json:"device,omitempty"`
+	Devices  []*clusterDevice `json:"devices,omitempty"`
+}
+
+type remoteInstance struct {
+	seenAt  time.Time
+	devices map[string]*clusterDevice
+}
+
+// cluster keeps track of the connections other instances hold, so presence and the
+// device list cover every instance and not just this one. Instances announce their
+// connections through the broker and heartbeat; a silent one is forgotten.
+type cluster struct {
+	hub        *Hub
+	instanceID string
+
+	mu        sync.Mutex
+	instances map[string]*remoteInstance
+	// users indexes the devices of all remote instances by user and device id
+	users map[uint]map[string]*clusterDevice
+}
+
+func newCluster(hub *Hub, instanceID string) *cluster {
+	return &cluster{
+		hub:        hub,
+		instanceID: instanceID,
+		instances:  make(map[string]*remoteInstance),
+		users:      make(map[uint]map[string]*clusterDevice),
+	}
+}
+
+// run asks the other instances for their connections and then heartbeats forever
+func (c *cluster) run() {
+	c.publish(&clusterEvent{Type: clusterHello})
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.publish(&clusterEvent{Type: clusterHeartbeat})
+		c.expire(time.Now())
+	}
+}
+
+func (c *cluster) publish(event *clusterEvent) {
+	event.Instance = c.instanceID
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("failed to marshal %s cluster event: %v", event.Type, err)
+		return
+	}
+
+	err = c.hub.broker.Publish(&broker.Message{Topic: topicConnections, Data: data})
+	if err != nil {
+		log.Printf("failed to publish %s cluster event: %v", event.Type, err)
+	}
+}
+
+func (c *cluster) Connected(userID uint, device *Device) {
+	c.publish(&clusterEvent{Type: clusterConnected, Device: newClusterDevice(userID, device)})
+}
+
+func (c *cluster) Disconnected(userID uint, device *Device) {
+	c.publish(&clusterEvent{Type: clusterDisconnected, Device: newClusterDevice(userID, device)})
+}
+
+// DisconnectDevice asks whichever instance holds a remote connection to close it and
+// reports whether such a connection is known
+func (c *cluster) DisconnectDevice(userID uint, deviceID string) bool {
+	c.mu.Lock()
+	device, ok := c.users[userID][deviceID]
+	c.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	c.publish(&clusterEvent{Type: clusterDisconnectDevice, Device: device})
+	return true
+}
+
+// HasDevices reports whether the user is connected to another instance
+func (c *cluster) HasDevices(userID uint) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.users[userID]) > 0
+}
+
+func (c *cluster) Devices(userID uint) []*Device {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	devices := make([]*Device, 0, len(c.users[userID]))
+	for _, device := range c.users[userID] {
+		devices = append(devices, &Device{
+			ID:          device.ID,
+			UserAgent:   device.UserAgent,
+			ConnectedAt: device.ConnectedAt,
+		})
+	}
+	return devices
+}
+
+// handle applies an event of another instance, it runs on the broker's goroutine
+func (c *cluster) handle(message *broker.Message) {
+	event := &clusterEvent{}
+	if err := json.Unmarshal(message.Data, event); err != nil {
+		log.Printf("failed to decode cluster event: %v", err)
+		return
+	}
+
+	if event.Instance == c.instanceID {
+		return
+	}
+
+	switch event.Type {
+	case clusterDisconnectDevice:
+		if event.Device != nil {
+			c.hub.disconnectLocalDevice(event.Device.UserID, event.Device.ID)
+		}
+		return
+	case clusterHello:
+		// answering from the broker's goroutine would hold up delivery for everyone
+		go c.publish(&clusterEvent{Type: clusterSnapshot, Devices: c.hub.localDevices()})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	instance := c.instances[event.Instance]
+	if instance == nil {
+		instance = &remoteInstance{devices: make(map[string]*clusterDevice)}
+		c.instances[event.Instance] = instance
+	}
+	instance.seenAt = time.Now()
+
+	switch event.Type {
+	case clusterConnected:
+		if event.Device != nil {
+			c.addDevice(instance, event.Device)
+		}
+	case clusterDisconnected:
+		if event.Device != nil {
+			c.removeDevice(instance, event.Device)
+		}
+	case clusterSnapshot:
+		for _, device := range instance.devices {
+			c.removeDevice(instance, device)
+		}
+		for _, device := range event.Devices {
+			c.addDevice(instance, device)
+		}
+	}
+}
+
+func (c *cluster) addDevice(instance *remoteInstance, device *clusterDevice) {
+	instance.devices[device.ID] = device
+
+	devices := c.users[device.UserID]
+	if devices == nil {
+		devices = make(map[string]*clusterDevice)
+		c.users[device.UserID] = devices
+	}
+	devices[device.ID] = device
+}
+
+func (c *cluster) removeDevice(instance *remoteInstance, device *clusterDevice) {
+	delete(instance.devices, device.ID)
+
+	devices := c.users[device.UserID]
+	delete(devices, device.ID)
+	if len(devices) == 0 {
+		delete(c.users, device.UserID)
+	}
+}
+
+// expire forgets instances that stopped heartbeating. Their users may have gone offline
+// with them; one instance, the one with the lowest id, announces that.
+func (c *cluster) expire(now time.Time) {
+	c.mu.Lock()
+
+	var orphaned []uint
+	leader := true
+	for id, instance := range c.instances {
+		if now.Sub(instance.seenAt) <= instanceTimeout {
+			if id < c.instanceID {
+				leader = false
+			}
+			continue
+		}
+
+		for _, device := range instance.devices {
+			c.removeDevice(instance, device)
+			if len(c.users[device.UserID]) == 0 {
+				orphaned = append(orphaned, device.UserID)
+			}
+		}
+		delete(c.instances, id)
+	}
+
+	c.mu.Unlock()
+
+	if !leader {
+		return
+	}
+
+	for _, userID := range uniqueUserIDs(orphaned) {
+		c.hub.presence.Lost(userID)
+	}
+}
+
+func newClusterDevice(userID uint, device *Device) *clusterDevice {
+	return &clusterDevice{
+		UserID:      userID,
+		ID:          device.ID,
+		UserAgent:   device.UserAgent,
+		ConnectedAt: device.ConnectedAt,
+	}
+}
+
+func uniqueUserIDs(userIDs []uint) []uint {
+	seen := make(map[uint]bool, len(userIDs))
+	unique := make([]uint, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if !seen[userID] {
+			seen[userID] = true
+			unique = append(unique, userID)
+		}
+	}
+	return unique
+}
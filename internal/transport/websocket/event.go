@@ -0,0 +1,126 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"simpleMessenger/internal/model"
+)
+
+// ProtocolVersion is bumped on every incompatible change of the frame format
+const ProtocolVersion = 1
+
+type EventType string
+
+const (
+	EventMessageCreated  EventType = "message.created"
+	EventMessageEdited   EventType = "message.edited"
+	EventMessageDeleted  EventType = "message.deleted"
+	EventChatDeleted     EventType = "chat.deleted"
+	EventReadUpdated     EventType = "read.updated"
+	EventDeliveryUpdated EventType = "delivery.updated"
+	EventTypingStarted   EventType = "typing.started"
+	EventTypingStopped   EventType = "typing.stopped"
+	EventPresenceUpdated EventType = "presence.updated"
+	EventReactionUpdated EventType = "reaction.updated"
+	EventThreadUpdated   EventType = "thread.updated"
+	// EventResyncRequired tells a client that frames meant for it were dropped
+	EventResyncRequired EventType = "resync.required"
+
+	// EventAck and EventError answer a client request and carry its id
+	EventAck   EventType = "ack"
+	EventError EventType = "error"
+)
+
+// Event is the envelope of every frame the server pushes to clients. Several frames may
+// arrive in one websocket message, separated by newlines.
+type Event struct {
+	Version int         `json:"v"`
+	Type    EventType   `json:"type"`
+	ID      string      `json:"id,omitempty"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+type MessageDeletedPayload struct {
+	MessageID uint   `json:"messageId"`
+	ChatID    uint   `json:"chatId"`
+	Seq       uint64 `json:"seq,omitempty"`
+}
+
+type ChatDeletedPayload struct {
+	ChatID uint `json:"chatId"`
+}
+
+// SyncResultPayload answers a sync request. Events of a chat are the same frames a live
+// connection would have got, in sequence order.
+type SyncResultPayload struct {
+	Chats          []*ChatSyncPayload `json:"chats"`
+	RemovedChatIDs []uint             `json:"removedChatIds"`
+	HasMore        bool               `json:"hasMore"`
+}
+
+type ChatSyncPayload struct {
+	ChatID  uint     `json:"chatId"`
+	LastSeq uint64   `json:"lastSeq"`
+	New     bool     `json:"new"`
+	Events  []*Event `json:"events"`
+}
+
+type ResyncPayload struct {
+	DroppedFrames uint64 `json:"droppedFrames"`
+}
+
+type ErrorPayload struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func (e *Event) Marshal() ([]byte, error) {
+	if e.Version == 0 {
+		e.Version = ProtocolVersion
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s event: %w", e.Type, err)
+	}
+	return data, nil
+}
+
+func newMessageEvent(eventType EventType, message *model.Message) *Event {
+	return &Event{Type: eventType, Payload: message}
+}
+
+// newReplayEvent turns the latest state of a message into the event a client that has
+// seen everything up to since is missing
+func newReplayEvent(message *model.Message, since uint64) *Event {
+	if message.DeletedAt.Valid {
+		return &Event{
+			Version: ProtocolVersion,
+			Type:    EventMessageDeleted,
+			Payload: &MessageDeletedPayload{
+				MessageID: message.ID,
+				ChatID:    message.ChatID,
+				Seq:       message.Seq,
+			},
+		}
+	}
+
+	event := newMessageEvent(EventMessageEdited, message)
+	if message.CreatedSeq > since {
+		event.Type = EventMessageCreated
+	}
+	event.Version = ProtocolVersion
+	return event
+}
+
+func newAckEvent(requestID string, payload interface{}) *Event {
+	return &Event{Type: EventAck, ID: requestID, Payload: payload}
+}
+
+func newErrorEvent(requestID string, code ErrorCode, message string) *Event {
+	return &Event{
+		Type:    EventError,
+		ID:      requestID,
+		Payload: &ErrorPayload{Code: code, Message: message},
+	}
+}
@@ -1,59 +1,271 @@
 package websocket
 
 import (
+	"github.com/gorilla/websocket"
+	"log"
+	"simpleMessenger/internal/broker"
+	"simpleMessenger/internal/model"
 	"simpleMessenger/internal/service"
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
 )
 
+// hubShards spreads connections over independent locks so connects of unrelated users
+// don't contend. It is a power of two to keep shard selection cheap.
+const hubShards = 256
+
+// hubShard holds the connections of the users whose id falls into it. Readers load an
+// immutable snapshot without locking; writers serialize on mu and swap in a modified copy.
+type hubShard struct {
+	mu    sync.Mutex
+	users atomic.Pointer[map[uint][]*Client]
+}
+
+// Hub tracks the connections of this instance. A connection is owned by its shard: only
+// removeClient, under the shard lock, takes it out of the snapshot and closes its done
+// channel, and does so once. send is never closed, so writing to it is always safe.
 type Hub struct {
-	clients        map[*Client]bool
-	register       chan *Client
-	unregister     chan *Client
-	mu             sync.RWMutex
+	shards         [hubShards]hubShard
 	messageService *service.MessageService
 	chatService    *service.ChatService
+	userService    *service.UserService
+	members        *service.MembershipCache
+	typing         *typingTracker
+	presence       *presenceTracker
+	cluster        *cluster
+	// broker carries frames to whichever instance the receiving users are connected to
+	broker             broker.Broker
+	slowConsumerPolicy SlowConsumerPolicy
+	// droppedFrames and slowConsumers add up over the lifetime of the hub, for operators
+	droppedFrames atomic.Uint64
+	slowConsumers atomic.Uint64
 }
 
-func NewHub(messageService *service.MessageService, chatService *service.ChatService) *Hub {
-	return &Hub{
-		clients:        make(map[*Client]bool),
-		register:       make(chan *Client),
-		unregister:     make(chan *Client),
-		messageService: messageService,
-		chatService:    chatService,
+func NewHub(broker broker.Broker, slowConsumerPolicy SlowConsumerPolicy, messageService *service.MessageService, chatService *service.ChatService, userService *service.UserService, members *service.MembershipCache) *Hub {
+	hub := &Hub{
+		broker:             broker,
+		slowConsumerPolicy: slowConsumerPolicy,
+		messageService:     messageService,
+		chatService:        chatService,
+		userService:        userService,
+		members:            members,
+	}
+	for i := range hub.shards {
+		empty := make(map[uint][]*Client)
+		hub.shards[i].users.Store(&empty)
 	}
+	instanceID, err := newDeviceID()
+	if err != nil {
+		log.Fatalf("failed to create hub instance id: %v", err)
+	}
+
+	hub.typing = newTypingTracker(hub)
+	hub.presence = newPresenceTracker(hub)
+	hub.cluster = newCluster(hub, instanceID)
+	broker.Subscribe(hub.deliver)
+	return hub
 }
 
+// Run processes background work of the hub and blocks forever
 func (h *Hub) Run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client] = true
-			h.mu.Unlock()
-		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-			}
+	go h.cluster.run()
+	h.presence.run()
+}
+
+func (h *Hub) shard(userID uint) *hubShard {
+	return &h.shards[userID%hubShards]
+}
+
+// connections returns the local connections of a user. The slice must not be modified.
+func (h *Hub) connections(userID uint) []*Client {
+	return (*h.shard(userID).users.Load())[userID]
+}
+
+func (h *Hub) Register(client *Client) {
+	h.addClient(client)
+	h.presence.Connected(client)
+}
+
+func (h *Hub) Unregister(client *Client) {
+	h.removeClient(client, websocket.CloseNormalClosure, "")
+}
+
+func (h *Hub) addClient(client *Client) {
+	shard := h.shard(client.userID)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	current := *shard.users.Load()
+	next := make(map[uint][]*Client, len(current)+1)
+	for userID, connections := range current {
+		next[userID] = connections
+	}
+
+	connections := make([]*Client, 0, len(current[client.userID])+1)
+	connections = append(connections, current[client.userID]...)
+	next[client.userID] = append(connections, client)
+
+	shard.users.Store(&next)
+}
+
+// removeClient drops a connection and closes its done channel, writePump then sends a
+// close frame with code and reason. A client removed twice is ignored the second time.
+func (h *Hub) removeClient(client *Client, code int, reason string) {
+	shard := h.shard(client.userID)
+
+	shard.mu.Lock()
+	current := *shard.users.Load()
+	connections := current[client.userID]
+
+	index := -1
+	for i, connection := range connections {
+		if connection == client {
+			index = i
+			break
 		}
 	}
+	if index < 0 {
+		shard.mu.Unlock()
+		return
+	}
+
+	next := make(map[uint][]*Client, len(current))
+	for userID, other := range current {
+		next[userID] = other
+	}
+	if len(connections) == 1 {
+		delete(next, client.userID)
+	} else {
+		remaining := make([]*Client, 0, len(connections)-1)
+		remaining = append(remaining, connections[:index]...)
+		next[client.userID] = append(remaining, connections[index+1:]...)
+	}
+
+	shard.users.Store(&next)
+	client.closeCode = code
+	client.closeReason = reason
+	close(client.done)
+	shard.mu.Unlock()
+
+	if code == CloseSlowConsumer {
+		h.slowConsumers.Add(1)
+	}
+
+	if h.presence.Disconnected(client) {
+		go h.typing.StopUser(client.userID)
+	}
+}
+
+// IsOnline reports whether the user has at least one open connection on any instance
+func (h *Hub) IsOnline(userID uint) bool {
+	return h.presence.IsOnline(userID) || h.cluster.HasDevices(userID)
 }
 
+// SendToUser delivers a frame to every connection of the user, on any instance
 func (h *Hub) SendToUser(userID uint, message []byte) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	for client := range h.clients {
-		if client.userID == userID {
-			select {
-			case client.send <- message:
-			default:
-				close(client.send)
-				delete(h.clients, client)
+	h.publish([]uint{userID}, message)
+}
+
+func (h *Hub) publish(userIDs []uint, message []byte) {
+	if len(userIDs) == 0 {
+		return
+	}
+
+	err := h.broker.Publish(&broker.Message{UserIDs: userIDs, Data: message})
+	if err != nil {
+		log.Printf("failed to publish to %d users: %v", len(userIDs), err)
+	}
+}
+
+// deliver hands a frame that came through the broker to the local connections of its users
+func (h *Hub) deliver(message *broker.Message) {
+	switch message.Topic {
+	case "":
+	case topicConnections:
+		h.cluster.handle(message)
+		return
+	default:
+		return
+	}
+
+	for _, userID := range message.UserIDs {
+		h.deliverToUser(userID, message.Data)
+	}
+}
+
+// deliverToUser writes a frame to every local connection of the user. Connections whose
+// buffer is full are dropped instead of stalling everyone else.
+func (h *Hub) deliverToUser(userID uint, message []byte) {
+	for _, client := range h.connections(userID) {
+		if !client.trySend(message) {
+			h.dropSlowConsumer(client)
+		}
+	}
+}
+
+// SendToClient delivers a frame to a single connection of this instance
+func (h *Hub) SendToClient(client *Client, message []byte) {
+	if !client.trySend(message) {
+		h.dropSlowConsumer(client)
+	}
+}
+
+func (h *Hub) dropSlowConsumer(client *Client) {
+	log.Printf("disconnecting slow websocket client of user %d with %d queued frames", client.userID, len(client.send))
+	h.removeClient(client, CloseSlowConsumer, "too slow to keep up, resync after reconnecting")
+}
+
+// Devices lists the open connections of a user on every instance, oldest first. Queue
+// metrics are only known for connections of this instance.
+func (h *Hub) Devices(userID uint) []*Device {
+	connections := h.connections(userID)
+
+	devices := make([]*Device, 0, len(connections))
+	for _, client := range connections {
+		devices = append(devices, client.device())
+	}
+	devices = append(devices, h.cluster.Devices(userID)...)
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ConnectedAt.Before(devices[j].ConnectedAt)
+	})
+	return devices
+}
+
+// DisconnectDevice closes one connection of a user, on whichever instance holds it, and
+// reports whether it was found
+func (h *Hub) DisconnectDevice(userID uint, deviceID string) bool {
+	if h.disconnectLocalDevice(userID, deviceID) {
+		return true
+	}
+	return h.cluster.DisconnectDevice(userID, deviceID)
+}
+
+func (h *Hub) disconnectLocalDevice(userID uint, deviceID string) bool {
+	for _, client := range h.connections(userID) {
+		if client.id == deviceID {
+			h.removeClient(client, CloseDeviceDisconnected, "disconnected from another device")
+			return true
+		}
+	}
+
+	return false
+}
+
+// localDevices lists every connection of this instance for the other instances
+func (h *Hub) localDevices() []*clusterDevice {
+	var devices []*clusterDevice
+	for i := range h.shards {
+		for userID, connections := range *h.shards[i].users.Load() {
+			for _, client := range connections {
+				devices = append(devices, newClusterDevice(userID, client.device()))
 			}
-			break
 		}
 	}
+	return devices
 }
 
 func (h *Hub) SendToChat(chatID, senderID uint, message []byte) error {
@@ -62,11 +274,119 @@ func (h *Hub) SendToChat(chatID, senderID uint, message []byte) error {
 		return err
 	}
 
-	for _, userID := range participants {
-		if userID == senderID {
-			//continue
-		}
-		h.SendToUser(userID, message)
+	// the sender is not skipped, their other devices have to see the message as well
+	h.publish(participants, message)
+	return nil
+}
+
+// PublishToChat pushes an event to every participant of a chat
+func (h *Hub) PublishToChat(chatID, senderID uint, event *Event) error {
+	data, err := event.Marshal()
+	if err != nil {
+		return err
+	}
+
+	return h.SendToChat(chatID, senderID, data)
+}
+
+// PublishToUsers pushes an event to the given users, for chats whose participants are already gone
+func (h *Hub) PublishToUsers(userIDs []uint, event *Event) error {
+	data, err := event.Marshal()
+	if err != nil {
+		return err
 	}
+
+	h.publish(userIDs, data)
 	return nil
 }
+
+// publishMessage pushes an event about a message to everyone who sees it: the whole chat
+// for timeline messages, otherwise the participants of its thread and whoever acted on it
+func (h *Hub) publishMessage(message *model.Message, actorID uint, event *Event) error {
+	if message.InTimeline() {
+		return h.PublishToChat(message.ChatID, actorID, event)
+	}
+
+	participants, err := h.messageService.GetThreadParticipants(*message.ThreadRootID, message.ChatID)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range []uint{message.UserID, actorID} {
+		if !slices.Contains(participants, userID) {
+			participants = append(participants, userID)
+		}
+	}
+
+	return h.PublishToUsers(participants, event)
+}
+
+// notifyThreadUpdated shows the chat the new reply counters of the thread a message is in
+func (h *Hub) notifyThreadUpdated(message *model.Message, actorID uint) error {
+	if message.ThreadRootID == nil {
+		return nil
+	}
+
+	summary, err := h.messageService.GetThreadSummary(*message.ThreadRootID)
+	if err != nil {
+		return err
+	}
+
+	return h.PublishToChat(summary.ChatID, actorID, &Event{
+		Type:    EventThreadUpdated,
+		Payload: summary,
+	})
+}
+
+func (h *Hub) NotifyMessageCreated(message *model.Message) error {
+	if err := h.publishMessage(message, message.UserID, newMessageEvent(EventMessageCreated, message)); err != nil {
+		return err
+	}
+	return h.notifyThreadUpdated(message, message.UserID)
+}
+
+func (h *Hub) NotifyMessageEdited(message *model.Message) error {
+	return h.publishMessage(message, message.UserID, newMessageEvent(EventMessageEdited, message))
+}
+
+func (h *Hub) NotifyMessageDeleted(message *model.Message, deletedBy uint) error {
+	err := h.publishMessage(message, deletedBy, &Event{
+		Type: EventMessageDeleted,
+		Payload: &MessageDeletedPayload{
+			MessageID: message.ID,
+			ChatID:    message.ChatID,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return h.notifyThreadUpdated(message, deletedBy)
+}
+
+func (h *Hub) NotifyChatDeleted(chatID uint, participants []uint) error {
+	return h.PublishToUsers(participants, &Event{
+		Type:    EventChatDeleted,
+		Payload: &ChatDeletedPayload{ChatID: chatID},
+	})
+}
+
+func (h *Hub) NotifyRead(receipt *model.ReadReceipt) error {
+	return h.PublishToChat(receipt.ChatID, receipt.UserID, &Event{
+		Type:    EventReadUpdated,
+		Payload: receipt,
+	})
+}
+
+func (h *Hub) NotifyReaction(update *model.ReactionUpdate) error {
+	return h.PublishToChat(update.ChatID, update.UserID, &Event{
+		Type:    EventReactionUpdated,
+		Payload: update,
+	})
+}
+
+func (h *Hub) NotifyDelivered(receipt *model.DeliveryReceipt) error {
+	return h.PublishToChat(receipt.ChatID, receipt.UserID, &Event{
+		Type:    EventDeliveryUpdated,
+		Payload: receipt,
+	})
+}
@@ -0,0 +1,103 @@
+package websocket
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"simpleMessenger/internal/broker"
+	"testing"
+)
+
+// newBenchHub connects one client per user and drains their send buffers, the way
+// writePump would, until the hub drops them
+func newBenchHub(b *testing.B, users int) *Hub {
+	b.Helper()
+
+	hub := NewHub(broker.NewMemoryBroker(), PolicyDisconnect, nil, nil, nil, nil)
+	clients := make([]*Client, 0, users)
+
+	for userID := 1; userID <= users; userID++ {
+		client := &Client{
+			hub:    hub,
+			userID: uint(userID),
+			send:   make(chan []byte, sendBufferSize),
+			done:   make(chan struct{}),
+		}
+		hub.addClient(client)
+		clients = append(clients, client)
+
+		go func() {
+			for {
+				select {
+				case <-client.send:
+				case <-client.done:
+					return
+				}
+			}
+		}()
+	}
+
+	b.Cleanup(func() {
+		for _, client := range clients {
+			hub.removeClient(client, websocket.CloseNormalClosure, "")
+		}
+	})
+
+	return hub
+}
+
+func BenchmarkSendToUser(b *testing.B) {
+	message := []byte(`{"v":1,"type":"message.created","payload":{}}`)
+
+	for _, users := range []int{10_000, 100_000} {
+		b.Run(fmt.Sprintf("clients=%d", users), func(b *testing.B) {
+			hub := newBenchHub(b, users)
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				userID := uint(0)
+				for pb.Next() {
+					userID = userID%uint(users) + 1
+					hub.SendToUser(userID, message)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkFanOut(b *testing.B) {
+	message := []byte(`{"v":1,"type":"message.created","payload":{}}`)
+
+	for _, users := range []int{10_000, 50_000} {
+		for _, recipients := range []int{100, 1_000, 10_000} {
+			b.Run(fmt.Sprintf("clients=%d/recipients=%d", users, recipients), func(b *testing.B) {
+				hub := newBenchHub(b, users)
+
+				userIDs := make([]uint, recipients)
+				for i := range userIDs {
+					userIDs[i] = uint(i*users/recipients + 1)
+				}
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					hub.publish(userIDs, message)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkConnect(b *testing.B) {
+	hub := newBenchHub(b, 10_000)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		client := &Client{
+			hub:    hub,
+			userID: uint(i%10_000 + 1),
+			send:   make(chan []byte, 1),
+			done:   make(chan struct{}),
+		}
+		hub.addClient(client)
+		hub.removeClient(client, websocket.CloseNormalClosure, "")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
+	"time"
 )
 
 var upgrader = websocket.Upgrader{
@@ -24,6 +25,13 @@ func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	deviceID, err := newDeviceID()
+	if err != nil {
+		log.Printf("Error while creating device id: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("Error while upgrading connection: %v", err)
@@ -31,15 +39,20 @@ func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	}
 
 	client := &Client{
-		hub:    hub,
-		conn:   conn,
-		send:   make(chan []byte, 256),
-		userID: userID,
+		hub:          hub,
+		conn:         conn,
+		send:         make(chan []byte, sendBufferSize),
+		done:         make(chan struct{}),
+		userID:       userID,
+		typingLimits: newRateLimiter(typingRateBurst, typingRateInterval),
+		id:           deviceID,
+		userAgent:    r.UserAgent(),
+		connectedAt:  time.Now(),
 	}
 
 	log.Printf("hello\n")
 
-	client.hub.register <- client
+	client.hub.Register(client)
 
 	go client.writePump()
 	log.Printf("writing gorutine for %v is running", userID)
@@ -0,0 +1,38 @@
+package websocket
+
+// HubStats sums up the connections of this instance for operators. Queue figures are a
+// snapshot, the frame and disconnect counters add up since the hub started.
+type HubStats struct {
+	Connections int `json:"connections"`
+	// QueuedFrames is how many frames wait in all send buffers together
+	QueuedFrames  int `json:"queuedFrames"`
+	MaxQueueDepth int `json:"maxQueueDepth"`
+	// FullQueues counts connections whose send buffer has no room left
+	FullQueues              int    `json:"fullQueues"`
+	DroppedFrames           uint64 `json:"droppedFrames"`
+	SlowConsumerDisconnects uint64 `json:"slowConsumerDisconnects"`
+}
+
+func (h *Hub) Stats() *HubStats {
+	stats := &HubStats{
+		DroppedFrames:           h.droppedFrames.Load(),
+		SlowConsumerDisconnects: h.slowConsumers.Load(),
+	}
+
+	for i := range h.shards {
+		for _, connections := range *h.shards[i].users.Load() {
+			for _, client := range connections {
+				depth := len(client.send)
+
+				stats.Connections++
+				stats.QueuedFrames += depth
+				stats.MaxQueueDepth = max(stats.MaxQueueDepth, depth)
+				if depth == cap(client.send) {
+					stats.FullQueues++
+				}
+			}
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,125 @@
+package websocket
+
+import (
+	"log"
+	"simpleMessenger/internal/model"
+	"sync"
+)
+
+type presenceChange struct {
+	userID uint
+	online bool
+	// device is the connection that opened or closed, nil when a user was lost together
+	// with another instance
+	device *Device
+	// transition is set for the first connection and the last disconnect of the user here
+	transition bool
+}
+
+// presenceTracker counts live connections per user. A user is online while at least one
+// of their connections is registered on any instance; only the first connect and the last
+// disconnect are announced to the people they share a chat with.
+type presenceTracker struct {
+	hub         *Hub
+	mu          sync.Mutex
+	connections map[uint]int
+	changes     chan presenceChange
+}
+
+func newPresenceTracker(hub *Hub) *presenceTracker {
+	return &presenceTracker{
+		hub:         hub,
+		connections: make(map[uint]int),
+		changes:     make(chan presenceChange, 256),
+	}
+}
+
+// run handles changes one by one so a quick reconnect can't overtake its own disconnect
+func (p *presenceTracker) run() {
+	for change := range p.changes {
+		if change.device != nil {
+			if change.online {
+				p.hub.cluster.Connected(change.userID, change.device)
+			} else {
+				p.hub.cluster.Disconnected(change.userID, change.device)
+			}
+		}
+
+		// connections on other instances keep the user online as they were
+		if !change.transition || p.hub.cluster.HasDevices(change.userID) {
+			continue
+		}
+
+		if err := p.announce(change); err != nil {
+			log.Printf("failed to announce presence of user %d: %v", change.userID, err)
+		}
+	}
+}
+
+func (p *presenceTracker) Connected(client *Client) {
+	p.mu.Lock()
+	p.connections[client.userID]++
+	first := p.connections[client.userID] == 1
+	p.mu.Unlock()
+
+	p.changes <- presenceChange{userID: client.userID, online: true, device: client.device(), transition: first}
+}
+
+// Disconnected reports whether that was the last connection of the user on this instance
+func (p *presenceTracker) Disconnected(client *Client) bool {
+	p.mu.Lock()
+	count, ok := p.connections[client.userID]
+	if !ok {
+		p.mu.Unlock()
+		return false
+	}
+	last := count == 1
+	if last {
+		delete(p.connections, client.userID)
+	} else {
+		p.connections[client.userID] = count - 1
+	}
+	p.mu.Unlock()
+
+	p.changes <- presenceChange{userID: client.userID, online: false, device: client.device(), transition: last}
+	return last
+}
+
+// Lost announces a user whose connections were all on an instance that went away,
+// unless they have connected here in the meantime
+func (p *presenceTracker) Lost(userID uint) {
+	if p.IsOnline(userID) {
+		return
+	}
+	p.changes <- presenceChange{userID: userID, online: false, transition: true}
+}
+
+// IsOnline reports whether the user has a connection on this instance
+func (p *presenceTracker) IsOnline(userID uint) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.connections[userID] > 0
+}
+
+func (p *presenceTracker) announce(change presenceChange) error {
+	presence := &model.Presence{UserID: change.userID, Online: change.online}
+
+	if !change.online {
+		lastSeen, err := p.hub.userService.RecordLastSeen(change.userID)
+		if err != nil {
+			return err
+		}
+		presence.LastSeenAt = lastSeen
+	}
+
+	contacts, err := p.hub.chatService.GetContacts(change.userID)
+	if err != nil {
+		return err
+	}
+
+	return p.hub.PublishToUsers(contacts, &Event{
+		Type:    EventPresenceUpdated,
+		Payload: presence,
+	})
+}
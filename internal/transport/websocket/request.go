@@ -0,0 +1,133 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	repoInterfaces "simpleMessenger/internal/repository/interfaces"
+	"simpleMessenger/internal/service"
+)
+
+type RequestType string
+
+const (
+	RequestSendMessage   RequestType = "message.send"
+	RequestEditMessage   RequestType = "message.edit"
+	RequestMarkRead      RequestType = "chat.read"
+	RequestMarkDelivered RequestType = "message.delivered"
+	RequestTypingStart   RequestType = "typing.start"
+	RequestTypingStop    RequestType = "typing.stop"
+	RequestSync          RequestType = "sync"
+	RequestReact         RequestType = "reaction.add"
+	RequestUnreact       RequestType = "reaction.remove"
+)
+
+// Request is the envelope of every frame a client sends. ID is chosen by the client
+// and echoed back in the ack or error answering the request.
+type Request struct {
+	Version int             `json:"v"`
+	Type    RequestType     `json:"type"`
+	ID      string          `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type SendMessagePayload struct {
+	ChatID           uint   `json:"chatId"`
+	Text             string `json:"text"`
+	ClientMsgID      string `json:"clientMsgId"`
+	AttachmentIDs    []uint `json:"attachmentIds"`
+	ReplyToMessageID uint   `json:"replyToMessageId"`
+	ThreadRootID     uint   `json:"threadRootId"`
+	AlsoSendToChat   bool   `json:"alsoSendToChat"`
+}
+
+type EditMessagePayload struct {
+	MessageID uint   `json:"messageId"`
+	Text      string `json:"text"`
+}
+
+// MarkReadPayload acknowledges everything in a chat up to MessageID, it is used for both reads and deliveries
+type MarkReadPayload struct {
+	ChatID    uint `json:"chatId"`
+	MessageID uint `json:"messageId"`
+}
+
+type TypingRequestPayload struct {
+	ChatID uint `json:"chatId"`
+}
+
+type ReactionPayload struct {
+	MessageID uint   `json:"messageId"`
+	Emoji     string `json:"emoji"`
+}
+
+// SyncPayload maps every chat the client has loaded to the last sequence number it saw there
+type SyncPayload struct {
+	Chats map[uint]uint64 `json:"chats"`
+}
+
+type ErrorCode string
+
+const (
+	ErrCodeBadRequest         ErrorCode = "bad_request"
+	ErrCodeUnsupportedVersion ErrorCode = "unsupported_version"
+	ErrCodeUnknownType        ErrorCode = "unknown_type"
+	ErrCodeForbidden          ErrorCode = "forbidden"
+	ErrCodeNotFound           ErrorCode = "not_found"
+	ErrCodeRateLimited        ErrorCode = "rate_limited"
+	ErrCodeInternal           ErrorCode = "internal"
+)
+
+// requestError is returned by request handlers when the client has to be told why the request failed
+type requestError struct {
+	code    ErrorCode
+	message string
+}
+
+func (e *requestError) Error() string {
+	return string(e.code) + ": " + e.message
+}
+
+func newRequestError(code ErrorCode, message string) error {
+	return &requestError{code: code, message: message}
+}
+
+// toRequestError maps service errors onto protocol error codes
+func toRequestError(err error) *requestError {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return reqErr
+	}
+
+	switch {
+	case errors.Is(err, service.ErrEmptyMessageText),
+		errors.Is(err, service.ErrClientMsgIDTooLong),
+		errors.Is(err, service.ErrTooManyAttachments),
+		errors.Is(err, service.ErrInvalidAttachment),
+		errors.Is(err, service.ErrInvalidEmoji),
+		errors.Is(err, service.ErrTooManyReactions),
+		errors.Is(err, service.ErrInvalidReplyTarget),
+		errors.Is(err, service.ErrInvalidThreadRoot):
+		return &requestError{code: ErrCodeBadRequest, message: err.Error()}
+	case errors.Is(err, service.ErrUserNotInChat),
+		errors.Is(err, service.ErrPermissionDenied),
+		errors.Is(err, service.ErrNotMessageAuthor),
+		errors.Is(err, service.ErrEditWindowExpired):
+		return &requestError{code: ErrCodeForbidden, message: err.Error()}
+	case errors.Is(err, repoInterfaces.ErrMessageNotFound),
+		errors.Is(err, repoInterfaces.ErrChatNotFound):
+		return &requestError{code: ErrCodeNotFound, message: err.Error()}
+	default:
+		return &requestError{code: ErrCodeInternal, message: "internal server error"}
+	}
+}
+
+func decodePayload(request *Request, payload interface{}) error {
+	if len(request.Payload) == 0 {
+		return newRequestError(ErrCodeBadRequest, "payload is required")
+	}
+
+	if err := json.Unmarshal(request.Payload, payload); err != nil {
+		return newRequestError(ErrCodeBadRequest, "invalid payload: "+err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package websocket
+
+import (
+	"log"
+	"simpleMessenger/internal/service"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// typingTimeout ends an indicator whose typing.stop never arrived
+	typingTimeout = 6 * time.Second
+
+	typingRateBurst    = 5
+	typingRateInterval = time.Second
+)
+
+type typingKey struct {
+	chatID uint
+	userID uint
+}
+
+type TypingPayload struct {
+	ChatID uint `json:"chatId"`
+	UserID uint `json:"userId"`
+}
+
+// typingTracker keeps "is typing" indicators in memory only and fans them out to the
+// other participants of a chat. Nothing about typing is ever persisted, and it never
+// reads the database either: participants come from the membership cache alone, and
+// typing in a chat the cache hasn't loaded yet is dropped.
+type typingTracker struct {
+	hub    *Hub
+	mu     sync.Mutex
+	timers map[typingKey]*time.Timer
+}
+
+func newTypingTracker(hub *Hub) *typingTracker {
+	return &typingTracker{
+		hub:    hub,
+		timers: make(map[typingKey]*time.Timer),
+	}
+}
+
+func (t *typingTracker) Start(chatID, userID uint) error {
+	participants, known, err := t.participantsOf(chatID, userID)
+	if err != nil || !known {
+		return err
+	}
+
+	key := typingKey{chatID: chatID, userID: userID}
+
+	t.mu.Lock()
+	timer, active := t.timers[key]
+	if active {
+		timer.Reset(typingTimeout)
+	} else {
+		t.timers[key] = time.AfterFunc(typingTimeout, func() { t.expire(key) })
+	}
+	t.mu.Unlock()
+
+	// repeated starts only keep the indicator alive
+	if active {
+		return nil
+	}
+
+	return t.publish(EventTypingStarted, key, participants)
+}
+
+func (t *typingTracker) Stop(chatID, userID uint) error {
+	participants, known, err := t.participantsOf(chatID, userID)
+	if err != nil || !known {
+		return err
+	}
+
+	key := typingKey{chatID: chatID, userID: userID}
+	if !t.remove(key) {
+		return nil
+	}
+
+	return t.publish(EventTypingStopped, key, participants)
+}
+
+// StopUser ends every indicator of a user, e.g. when their connection goes away
+func (t *typingTracker) StopUser(userID uint) {
+	t.mu.Lock()
+	var keys []typingKey
+	for key := range t.timers {
+		if key.userID == userID {
+			keys = append(keys, key)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, key := range keys {
+		t.expire(key)
+	}
+}
+
+func (t *typingTracker) expire(key typingKey) {
+	if !t.remove(key) {
+		return
+	}
+
+	participants, known := t.hub.members.Peek(key.chatID)
+	if !known {
+		return
+	}
+
+	if err := t.publish(EventTypingStopped, key, participants); err != nil {
+		log.Printf("failed to expire typing indicator in chat %d: %v", key.chatID, err)
+	}
+}
+
+func (t *typingTracker) remove(key typingKey) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timer, ok := t.timers[key]
+	if !ok {
+		return false
+	}
+
+	timer.Stop()
+	delete(t.timers, key)
+	return true
+}
+
+// participantsOf returns the cached chat participants, failing if userID is not one of
+// them. known is false when the chat is not cached, the event is dropped then.
+func (t *typingTracker) participantsOf(chatID, userID uint) (participants []uint, known bool, err error) {
+	participants, known = t.hub.members.Peek(chatID)
+	if !known {
+		return nil, false, nil
+	}
+
+	if !slices.Contains(participants, userID) {
+		return nil, true, service.ErrUserNotInChat
+	}
+	return participants, true, nil
+}
+
+func (t *typingTracker) publish(eventType EventType, key typingKey, participants []uint) error {
+	others := make([]uint, 0, len(participants))
+	for _, participant := range participants {
+		if participant != key.userID {
+			others = append(others, participant)
+		}
+	}
+
+	return t.hub.PublishToUsers(others, &Event{
+		Type:    eventType,
+		Payload: &TypingPayload{ChatID: key.chatID, UserID: key.userID},
+	})
+}
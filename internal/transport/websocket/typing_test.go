@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"errors"
+	"simpleMessenger/internal/broker"
+	"simpleMessenger/internal/model"
+	repoInterfaces "simpleMessenger/internal/repository/interfaces"
+	"simpleMessenger/internal/service"
+	"strings"
+	"testing"
+)
+
+// guardedParticipantsRepo serves participants only until the test forbids the database
+type guardedParticipantsRepo struct {
+	repoInterfaces.ChatParticipantsRepo
+	t         *testing.T
+	forbidden bool
+}
+
+func (r *guardedParticipantsRepo) GetParticipantsByChatID(chatID uint) ([]*model.ChatParticipants, error) {
+	if r.forbidden {
+		r.t.Errorf("typing read the participants of chat %d from the database", chatID)
+		return nil, errors.New("database is off limits")
+	}
+	return []*model.ChatParticipants{
+		{ChatID: chatID, UserID: 1, Role: model.ChatRoleMember},
+		{ChatID: chatID, UserID: 2, Role: model.ChatRoleMember},
+	}, nil
+}
+
+func TestTypingNeverReadsTheDatabase(t *testing.T) {
+	hubBroker := broker.NewMemoryBroker()
+	repo := &guardedParticipantsRepo{t: t}
+	members := service.NewMembershipCache(repo, hubBroker)
+	hub := NewHub(hubBroker, PolicyDisconnect, nil, nil, nil, members)
+
+	// user 2 watches chat 1
+	watcher := newTestClient(PolicyDisconnect, 8)
+	watcher.hub = hub
+	watcher.userID = 2
+	hub.addClient(watcher)
+
+	// opening the chat elsewhere loaded its members, from here on only the cache may be used
+	if _, err := members.UserIDs(1); err != nil {
+		t.Fatalf("UserIDs() error = %v", err)
+	}
+	repo.forbidden = true
+
+	if err := hub.typing.Start(1, 1); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := hub.typing.Stop(1, 1); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	frames := strings.Join(drain(watcher), "\n")
+	if !strings.Contains(frames, string(EventTypingStarted)) || !strings.Contains(frames, string(EventTypingStopped)) {
+		t.Errorf("watcher got %q, want typing started and stopped", frames)
+	}
+
+	if err := hub.typing.Start(1, 3); !errors.Is(err, service.ErrUserNotInChat) {
+		t.Errorf("Start() by an outsider error = %v, want ErrUserNotInChat", err)
+	}
+
+	// a chat nobody loaded yet drops the indicator instead of querying
+	if err := hub.typing.Start(2, 1); err != nil {
+		t.Errorf("Start() in an uncached chat error = %v, want it dropped", err)
+	}
+	hub.typing.StopUser(1)
+	if frames := drain(watcher); len(frames) != 0 {
+		t.Errorf("watcher got %v for an uncached chat, want nothing", frames)
+	}
+}